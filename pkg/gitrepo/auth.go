@@ -0,0 +1,191 @@
+package gitrepo
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultSSHUser = "git"
+
+// newAuth creates transport.AuthMethod out of authentication settings of the
+// config. It returns nil auth when no authentication is configured in which
+// case go-git defaults are used.
+func newAuth(config Config) (transport.AuthMethod, error) {
+	var methods int
+	for _, set := range []bool{
+		config.AuthBasicToken != "",
+		config.AuthSSHPrivateKeyFile != "" || len(config.AuthSSHPrivateKey) > 0,
+		config.AuthSSHAgent,
+	} {
+		if set {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("only one of %T.AuthBasicToken, %T.AuthSSHPrivateKey(File) and %T.AuthSSHAgent can be set", config, config, config)}
+	}
+	if config.AuthSSHPrivateKeyFile != "" && len(config.AuthSSHPrivateKey) > 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.AuthSSHPrivateKeyFile and %T.AuthSSHPrivateKey must not be set together", config, config)}
+	}
+	if len(config.AuthSSHKnownHostsFiles) > 0 && len(config.AuthSSHHostKeys) > 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.AuthSSHKnownHostsFiles and %T.AuthSSHHostKeys must not be set together", config, config)}
+	}
+
+	if config.AuthBasicToken != "" {
+		auth := &http.BasicAuth{
+			Username: "can-be-anything-but-not-empty",
+			Password: config.AuthBasicToken,
+		}
+
+		return auth, nil
+	}
+
+	user, err := sshUser(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var helper *gitssh.HostKeyCallbackHelper
+	var auth transport.AuthMethod
+	switch {
+	case config.AuthSSHPrivateKeyFile != "":
+		keys, err := gitssh.NewPublicKeysFromFile(user, config.AuthSSHPrivateKeyFile, config.AuthSSHPrivateKeyPassword)
+		if err != nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("failed to load %T.AuthSSHPrivateKeyFile %#q with error %#q", config, config.AuthSSHPrivateKeyFile, err)}
+		}
+
+		helper = &keys.HostKeyCallbackHelper
+		auth = keys
+	case len(config.AuthSSHPrivateKey) > 0:
+		keys, err := gitssh.NewPublicKeys(user, config.AuthSSHPrivateKey, config.AuthSSHPrivateKeyPassword)
+		if err != nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("failed to parse %T.AuthSSHPrivateKey with error %#q", config, err)}
+		}
+
+		helper = &keys.HostKeyCallbackHelper
+		auth = keys
+	case config.AuthSSHAgent:
+		callback, err := gitssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("failed to connect to SSH agent with error %#q", err)}
+		}
+
+		helper = &callback.HostKeyCallbackHelper
+		auth = callback
+	default:
+		if len(config.AuthSSHKnownHostsFiles) > 0 || len(config.AuthSSHHostKeys) > 0 {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("SSH host key verification requires %T.AuthSSHPrivateKey(File) or %T.AuthSSHAgent to be set", config, config)}
+		}
+
+		return nil, nil
+	}
+
+	switch {
+	case len(config.AuthSSHKnownHostsFiles) > 0:
+		db, err := gitssh.NewKnownHostsDb(config.AuthSSHKnownHostsFiles...)
+		if err != nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("failed to load %T.AuthSSHKnownHostsFiles with error %#q", config, err)}
+		}
+
+		helper.HostKeyCallback = db.HostKeyCallback()
+
+		// Restrict host key algorithms to the ones known for the host.
+		// Otherwise the server may offer a key of a different type
+		// than the one recorded in known_hosts and verification fails.
+		if config.URL != "" {
+			endpoint, err := transport.NewEndpoint(config.URL)
+			if err == nil {
+				port := endpoint.Port
+				if port == 0 {
+					port = 22
+				}
+				helper.HostKeyAlgorithms = db.HostKeyAlgorithms(net.JoinHostPort(endpoint.Host, strconv.Itoa(port)))
+			}
+		}
+	case len(config.AuthSSHHostKeys) > 0:
+		var keys []ssh.PublicKey
+		for _, k := range config.AuthSSHHostKeys {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+			if err != nil {
+				return nil, &InvalidConfigError{message: fmt.Sprintf("failed to parse %T.AuthSSHHostKeys entry %#q with error %#q", config, k, err)}
+			}
+
+			keys = append(keys, key)
+		}
+
+		helper.HostKeyCallback = pinnedHostKeysCallback(keys)
+		helper.HostKeyAlgorithms = hostKeyAlgorithms(keys)
+	}
+
+	return auth, nil
+}
+
+// sshUser returns the user for SSH authentication. It is
+// Config.AuthSSHUser or the user of the SSH URL, e.g. "deploy" in
+// "ssh://deploy@example.com/repo.git", and defaults to "git". It returns
+// InvalidConfigError when both are set and differ.
+func sshUser(config Config) (string, error) {
+	var urlUser string
+	if config.URL != "" {
+		endpoint, err := transport.NewEndpoint(config.URL)
+		if err == nil && endpoint.Protocol == "ssh" {
+			urlUser = endpoint.User
+		}
+	}
+
+	switch {
+	case config.AuthSSHUser != "" && urlUser != "" && config.AuthSSHUser != urlUser:
+		return "", &InvalidConfigError{message: fmt.Sprintf("%T.AuthSSHUser %#q does not match user %#q of %T.URL", config, config.AuthSSHUser, urlUser, config)}
+	case config.AuthSSHUser != "":
+		return config.AuthSSHUser, nil
+	case urlUser != "":
+		return urlUser, nil
+	default:
+		return defaultSSHUser, nil
+	}
+}
+
+// pinnedHostKeysCallback returns ssh.HostKeyCallback accepting only the given
+// host keys.
+func pinnedHostKeysCallback(keys []ssh.PublicKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("ssh: host key %s %s for %#q is not pinned", key.Type(), ssh.FingerprintSHA256(key), hostname)
+	}
+}
+
+// hostKeyAlgorithms returns the host key algorithms matching the given keys.
+func hostKeyAlgorithms(keys []ssh.PublicKey) []string {
+	var algorithms []string
+	seen := map[string]bool{}
+	add := func(a string) {
+		if !seen[a] {
+			seen[a] = true
+			algorithms = append(algorithms, a)
+		}
+	}
+
+	for _, k := range keys {
+		// RSA keys can be used with SHA-2 signature algorithms which
+		// servers prefer over the legacy ssh-rsa one.
+		if k.Type() == ssh.KeyAlgoRSA {
+			add(ssh.KeyAlgoRSASHA512)
+			add(ssh.KeyAlgoRSASHA256)
+		}
+		add(k.Type())
+	}
+
+	return algorithms
+}
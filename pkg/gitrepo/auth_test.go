@@ -0,0 +1,506 @@
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Test_Repo_EnsureUpToDate_ssh tests cloning over SSH with different
+// authentication and host key verification settings against a local SSH
+// server.
+func Test_Repo_EnsureUpToDate_ssh(t *testing.T) {
+	t.Parallel()
+
+	clientKey := newTestSSHKey(t)
+	server := newTestSSHServer(t, clientKey.PublicKey())
+	otherHostKey := newTestSSHKey(t)
+
+	origin := newTestOrigin(t)
+	head := origin.commit(nil, map[string]string{"a": "0"}, "c0", time.Now())
+	origin.branch("master", head)
+
+	const passphrase = "secret"
+
+	var keyPEM []byte
+	{
+		block, err := ssh.MarshalPrivateKeyWithPassphrase(clientKey.privateKey, "", []byte(passphrase))
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+		keyPEM = pem.EncodeToMemory(block)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	{
+		block, err := ssh.MarshalPrivateKey(clientKey.privateKey, "")
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+	}
+
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	{
+		line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, server.hostKey) + "\n"
+		err := os.WriteFile(knownHostsFile, []byte(line), 0600)
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		config       Config
+		expectedFail bool
+	}{
+		{
+			name: "case 0: encrypted private key with pinned host key",
+			config: Config{
+				AuthSSHPrivateKey:         keyPEM,
+				AuthSSHPrivateKeyPassword: passphrase,
+				AuthSSHHostKeys:           []string{string(ssh.MarshalAuthorizedKey(server.hostKey))},
+			},
+		},
+		{
+			name: "case 1: private key file with known_hosts file",
+			config: Config{
+				AuthSSHPrivateKeyFile:  keyFile,
+				AuthSSHKnownHostsFiles: []string{knownHostsFile},
+			},
+		},
+		{
+			name: "case 2: host key not pinned",
+			config: Config{
+				AuthSSHPrivateKeyFile: keyFile,
+				AuthSSHHostKeys:       []string{string(ssh.MarshalAuthorizedKey(otherHostKey.PublicKey()))},
+			},
+			expectedFail: true,
+		},
+		{
+			name: "case 3: unauthorized private key",
+			config: Config{
+				AuthSSHPrivateKey: otherHostKey.pem(t),
+				AuthSSHHostKeys:   []string{string(ssh.MarshalAuthorizedKey(server.hostKey))},
+			},
+			expectedFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			c := tc.config
+			c.Dir = filepath.Join(t.TempDir(), "clone")
+			c.URL = server.url(origin.dir)
+
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			err = repo.EnsureUpToDate(context.Background())
+			if tc.expectedFail {
+				if err == nil {
+					t.Fatalf("err = nil, want non-nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			sha, err := repo.HeadSHA(context.Background())
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if sha != head.String() {
+				t.Fatalf("sha = %s, want %s", sha, head)
+			}
+		})
+	}
+}
+
+// Test_Repo_EnsureUpToDate_sshAgent tests cloning over SSH with keys
+// provided by ssh-agent. It is not parallel because it sets SSH_AUTH_SOCK.
+func Test_Repo_EnsureUpToDate_sshAgent(t *testing.T) {
+	clientKey := newTestSSHKey(t)
+	server := newTestSSHServer(t, clientKey.PublicKey())
+
+	origin := newTestOrigin(t)
+	head := origin.commit(nil, map[string]string{"a": "0"}, "c0", time.Now())
+	origin.branch("master", head)
+
+	// Start ssh-agent holding the client key.
+	{
+		keyring := agent.NewKeyring()
+		err := keyring.Add(agent.AddedKey{PrivateKey: clientKey.privateKey})
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+
+		// Unix socket paths are limited in length so t.TempDir may be
+		// too long.
+		dir, err := os.MkdirTemp("", "gitrepo-agent")
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+		sock := filepath.Join(dir, "agent.sock")
+		l, err := net.Listen("unix", sock)
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+		t.Cleanup(func() { _ = l.Close() })
+
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer func() { _ = conn.Close() }()
+					_ = agent.ServeAgent(keyring, conn)
+				}()
+			}
+		}()
+
+		t.Setenv("SSH_AUTH_SOCK", sock)
+	}
+
+	c := Config{
+		Dir:             filepath.Join(t.TempDir(), "clone"),
+		URL:             server.url(origin.dir),
+		AuthSSHAgent:    true,
+		AuthSSHHostKeys: []string{string(ssh.MarshalAuthorizedKey(server.hostKey))},
+	}
+
+	repo, err := New(c)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	err = repo.EnsureUpToDate(context.Background())
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	sha, err := repo.HeadSHA(context.Background())
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	if sha != head.String() {
+		t.Fatalf("sha = %s, want %s", sha, head)
+	}
+}
+
+func Test_newAuth_invalidConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		config        Config
+		expectedError error
+	}{
+		{
+			name: "case 0: basic token and SSH key",
+			config: Config{
+				AuthBasicToken:        "token",
+				AuthSSHPrivateKeyFile: "/path/to/key",
+			},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name: "case 1: SSH key file and SSH key",
+			config: Config{
+				AuthSSHPrivateKeyFile: "/path/to/key",
+				AuthSSHPrivateKey:     []byte("key"),
+			},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name: "case 2: known hosts and pinned host keys",
+			config: Config{
+				AuthSSHAgent:           true,
+				AuthSSHKnownHostsFiles: []string{"/path/to/known_hosts"},
+				AuthSSHHostKeys:        []string{"ssh-ed25519 AAAA"},
+			},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name: "case 3: pinned host keys without SSH authentication",
+			config: Config{
+				AuthSSHHostKeys: []string{"ssh-ed25519 AAAA"},
+			},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name: "case 4: invalid private key",
+			config: Config{
+				AuthSSHPrivateKey: []byte("not a key"),
+			},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name: "case 5: SSH user not matching user of URL",
+			config: Config{
+				URL:          "ssh://deploy@example.com/repo.git",
+				AuthSSHUser:  "git",
+				AuthSSHAgent: true,
+			},
+			expectedError: &InvalidConfigError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			_, err := newAuth(tc.config)
+			if reflect.TypeOf(err) != reflect.TypeOf(tc.expectedError) {
+				t.Fatalf("err = %#v, want %#v", err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func Test_sshUser(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		config       Config
+		expectedUser string
+	}{
+		{
+			name:         "case 0: default",
+			config:       Config{URL: "ssh://example.com/repo.git"},
+			expectedUser: "git",
+		},
+		{
+			name:         "case 1: user of URL",
+			config:       Config{URL: "ssh://deploy@example.com/repo.git"},
+			expectedUser: "deploy",
+		},
+		{
+			name:         "case 2: user of scp-like URL",
+			config:       Config{URL: "deploy@example.com:org/repo.git"},
+			expectedUser: "deploy",
+		},
+		{
+			name:         "case 3: configured user",
+			config:       Config{URL: "ssh://example.com/repo.git", AuthSSHUser: "deploy"},
+			expectedUser: "deploy",
+		},
+		{
+			name:         "case 4: configured user matching user of URL",
+			config:       Config{URL: "ssh://deploy@example.com/repo.git", AuthSSHUser: "deploy"},
+			expectedUser: "deploy",
+		},
+		{
+			name:         "case 5: user of HTTP URL is ignored",
+			config:       Config{URL: "https://token@example.com/repo.git", AuthSSHUser: "deploy"},
+			expectedUser: "deploy",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			user, err := sshUser(tc.config)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if user != tc.expectedUser {
+				t.Fatalf("user = %q, want %q", user, tc.expectedUser)
+			}
+		})
+	}
+}
+
+type testSSHKey struct {
+	privateKey ed25519.PrivateKey
+	signer     ssh.Signer
+}
+
+func newTestSSHKey(t *testing.T) *testSSHKey {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	k := &testSSHKey{
+		privateKey: privateKey,
+		signer:     signer,
+	}
+
+	return k
+}
+
+func (k *testSSHKey) PublicKey() ssh.PublicKey {
+	return k.signer.PublicKey()
+}
+
+func (k *testSSHKey) pem(t *testing.T) []byte {
+	t.Helper()
+
+	block, err := ssh.MarshalPrivateKey(k.privateKey, "")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	return pem.EncodeToMemory(block)
+}
+
+// testSSHServer is a minimal SSH server serving git-upload-pack and
+// git-receive-pack for repositories on the local filesystem.
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+}
+
+func newTestSSHServer(t *testing.T, authorizedKey ssh.PublicKey) *testSSHServer {
+	t.Helper()
+
+	hostKey := newTestSSHKey(t)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized key for %q", conn.User())
+		},
+	}
+	config.AddHostKey(hostKey.signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, config)
+		}
+	}()
+
+	s := &testSSHServer{
+		addr:    l.Addr().String(),
+		hostKey: hostKey.PublicKey(),
+	}
+
+	return s
+}
+
+func (s *testSSHServer) url(path string) string {
+	return fmt.Sprintf("ssh://git@%s%s", s.addr, path)
+}
+
+func serveTestSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer func() { _ = conn.Close() }()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go serveTestSSHSession(channel, requests)
+	}
+}
+
+func serveTestSSHSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() { _ = channel.Close() }()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		err := ssh.Unmarshal(req.Payload, &payload)
+		if err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+
+		name, path, _ := strings.Cut(payload.Command, " ")
+		if name != "git-upload-pack" && name != "git-receive-pack" {
+			_ = req.Reply(false, nil)
+			return
+		}
+		_ = req.Reply(true, nil)
+
+		// #nosec G204
+		cmd := exec.Command(name, strings.Trim(path, "'"))
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, _ = io.Copy(stdin, channel)
+			_ = stdin.Close()
+		}()
+
+		var status uint32
+		err = cmd.Run()
+		if err != nil {
+			status = 1
+		}
+
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
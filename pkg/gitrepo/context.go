@@ -0,0 +1,32 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+)
+
+// checkContext returns CanceledError if the context is done.
+func checkContext(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		return &CanceledError{message: err.Error(), cause: err}
+	}
+
+	return nil
+}
+
+// contextError converts err returned by an operation run with ctx to
+// CanceledError if the context is done. go-git does not consistently wrap
+// context errors, e.g. killed transport processes or closed connections
+// surface as their own errors, so the context state is checked instead.
+func contextError(ctx context.Context, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return &CanceledError{message: fmt.Sprintf("%s: %s", op, ctxErr), cause: ctxErr}
+	}
+
+	return err
+}
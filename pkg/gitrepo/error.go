@@ -2,6 +2,8 @@ package gitrepo
 
 import (
 	"reflect"
+
+	"github.com/go-errors/errors"
 )
 
 type ExecutionFailedError struct {
@@ -16,6 +18,11 @@ func (e *ExecutionFailedError) Is(target error) bool {
 	return reflect.TypeOf(target) == reflect.TypeOf(e)
 }
 
+// IsExecutionFailed returns true if err matches ExecutionFailedError.
+func IsExecutionFailed(err error) bool {
+	return errors.Is(err, &ExecutionFailedError{})
+}
+
 type InvalidConfigError struct {
 	message string
 }
@@ -28,7 +35,15 @@ func (e *InvalidConfigError) Is(target error) bool {
 	return reflect.TypeOf(target) == reflect.TypeOf(e)
 }
 
+// IsInvalidConfig returns true if err matches InvalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, &InvalidConfigError{})
+}
+
 type FileNotFoundError struct {
+	// Path is the path of the file in the repository.
+	Path string
+
 	message string
 }
 
@@ -40,7 +55,15 @@ func (e *FileNotFoundError) Is(target error) bool {
 	return reflect.TypeOf(target) == reflect.TypeOf(e)
 }
 
+// IsFileNotFound returns true if err matches FileNotFoundError.
+func IsFileNotFound(err error) bool {
+	return errors.Is(err, &FileNotFoundError{})
+}
+
 type FolderNotFoundError struct {
+	// Path is the path of the folder in the repository.
+	Path string
+
 	message string
 }
 
@@ -52,7 +75,15 @@ func (e *FolderNotFoundError) Is(target error) bool {
 	return reflect.TypeOf(target) == reflect.TypeOf(e)
 }
 
+// IsFolderNotFound returns true if err matches FolderNotFoundError.
+func IsFolderNotFound(err error) bool {
+	return errors.Is(err, &FolderNotFoundError{})
+}
+
 type ReferenceNotFoundError struct {
+	// Ref is the reference which was not found, e.g. "master" or "HEAD".
+	Ref string
+
 	message string
 }
 
@@ -64,7 +95,15 @@ func (e *ReferenceNotFoundError) Is(target error) bool {
 	return reflect.TypeOf(target) == reflect.TypeOf(e)
 }
 
+// IsReferenceNotFound returns true if err matches ReferenceNotFoundError.
+func IsReferenceNotFound(err error) bool {
+	return errors.Is(err, &ReferenceNotFoundError{})
+}
+
 type RepositoryNotFoundError struct {
+	// URL is the URL of the remote with credentials redacted.
+	URL string
+
 	message string
 }
 
@@ -75,3 +114,227 @@ func (e *RepositoryNotFoundError) Error() string {
 func (e *RepositoryNotFoundError) Is(target error) bool {
 	return reflect.TypeOf(target) == reflect.TypeOf(e)
 }
+
+// IsRepositoryNotFound returns true if err matches RepositoryNotFoundError.
+func IsRepositoryNotFound(err error) bool {
+	return errors.Is(err, &RepositoryNotFoundError{})
+}
+
+// CanceledError is returned when an operation is stopped because its context
+// is canceled or its deadline is exceeded. It wraps the context error so it
+// can be matched with context.Canceled and context.DeadlineExceeded.
+type CanceledError struct {
+	message string
+	cause   error
+}
+
+func (e *CanceledError) Error() string {
+	return "CanceledError: " + e.message
+}
+
+func (e *CanceledError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsCanceled returns true if err matches CanceledError.
+func IsCanceled(err error) bool {
+	return errors.Is(err, &CanceledError{})
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.cause
+}
+
+// TagAlreadyExistsError is returned when a tag is created or pushed and a tag
+// with the same name already points to a different object.
+type TagAlreadyExistsError struct {
+	message string
+}
+
+func (e *TagAlreadyExistsError) Error() string {
+	return "TagAlreadyExistsError: " + e.message
+}
+
+func (e *TagAlreadyExistsError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsTagAlreadyExists returns true if err matches TagAlreadyExistsError.
+func IsTagAlreadyExists(err error) bool {
+	return errors.Is(err, &TagAlreadyExistsError{})
+}
+
+// ShallowHistoryError is returned when the history of a shallow clone does
+// not contain enough commits to resolve a version and it cannot be deepened.
+type ShallowHistoryError struct {
+	message string
+}
+
+func (e *ShallowHistoryError) Error() string {
+	return "ShallowHistoryError: " + e.message
+}
+
+func (e *ShallowHistoryError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsShallowHistory returns true if err matches ShallowHistoryError.
+func IsShallowHistory(err error) bool {
+	return errors.Is(err, &ShallowHistoryError{})
+}
+
+// RepositoryLockedError is returned when the lock of the repository directory
+// held by another process is not obtained within Config.LockTimeout.
+type RepositoryLockedError struct {
+	message string
+}
+
+func (e *RepositoryLockedError) Error() string {
+	return "RepositoryLockedError: " + e.message
+}
+
+func (e *RepositoryLockedError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsRepositoryLocked returns true if err matches RepositoryLockedError.
+func IsRepositoryLocked(err error) bool {
+	return errors.Is(err, &RepositoryLockedError{})
+}
+
+// RepositoryCorruptedError is returned by EnsureUpToDate when the existing
+// repository fails the integrity check and Config.RecoverCorrupted is not
+// set.
+type RepositoryCorruptedError struct {
+	message string
+	cause   error
+}
+
+func (e *RepositoryCorruptedError) Error() string {
+	return "RepositoryCorruptedError: " + e.message
+}
+
+func (e *RepositoryCorruptedError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsRepositoryCorrupted returns true if err matches RepositoryCorruptedError.
+func IsRepositoryCorrupted(err error) bool {
+	return errors.Is(err, &RepositoryCorruptedError{})
+}
+
+func (e *RepositoryCorruptedError) Unwrap() error {
+	return e.cause
+}
+
+// RemoteURLMismatchError is returned by EnsureUpToDate when the existing
+// repository was cloned from another URL than Config.URL and
+// Config.UpdateRemoteURL is not set.
+type RemoteURLMismatchError struct {
+	// URL is Config.URL with credentials redacted.
+	URL string
+
+	message string
+}
+
+func (e *RemoteURLMismatchError) Error() string {
+	return "RemoteURLMismatchError: " + e.message
+}
+
+func (e *RemoteURLMismatchError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsRemoteURLMismatch returns true if err matches RemoteURLMismatchError.
+func IsRemoteURLMismatch(err error) bool {
+	return errors.Is(err, &RemoteURLMismatchError{})
+}
+
+// AuthenticationFailedError is returned when the remote rejects the
+// credentials, or no credentials are configured and the remote requires them.
+// It is also returned when the SSH host key or the TLS certificate of the
+// remote cannot be verified. Retrying does not help.
+type AuthenticationFailedError struct {
+	// URL is the URL of the remote with credentials redacted.
+	URL string
+
+	message string
+	cause   error
+}
+
+func (e *AuthenticationFailedError) Error() string {
+	return "AuthenticationFailedError: " + e.message
+}
+
+func (e *AuthenticationFailedError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsAuthenticationFailed returns true if err matches AuthenticationFailedError.
+func IsAuthenticationFailed(err error) bool {
+	return errors.Is(err, &AuthenticationFailedError{})
+}
+
+func (e *AuthenticationFailedError) Unwrap() error {
+	return e.cause
+}
+
+// AuthorizationFailedError is returned when the credentials are accepted but
+// do not grant access to the repository. Retrying does not help.
+type AuthorizationFailedError struct {
+	// URL is the URL of the remote with credentials redacted.
+	URL string
+
+	message string
+	cause   error
+}
+
+func (e *AuthorizationFailedError) Error() string {
+	return "AuthorizationFailedError: " + e.message
+}
+
+func (e *AuthorizationFailedError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsAuthorizationFailed returns true if err matches AuthorizationFailedError.
+func IsAuthorizationFailed(err error) bool {
+	return errors.Is(err, &AuthorizationFailedError{})
+}
+
+func (e *AuthorizationFailedError) Unwrap() error {
+	return e.cause
+}
+
+// NetworkError is returned when the remote cannot be reached or the
+// connection fails, e.g. because of DNS, TLS or connection errors, timeouts or
+// server errors. The failure is usually temporary.
+type NetworkError struct {
+	// URL is the URL of the remote with credentials redacted.
+	URL string
+	// Timeout is true when the failure is caused by a timeout.
+	Timeout bool
+	// StatusCode is the HTTP status code of server errors. It is 0 for
+	// other failures.
+	StatusCode int
+
+	message string
+	cause   error
+}
+
+func (e *NetworkError) Error() string {
+	return "NetworkError: " + e.message
+}
+
+func (e *NetworkError) Is(target error) bool {
+	return reflect.TypeOf(target) == reflect.TypeOf(e)
+}
+
+// IsNetwork returns true if err matches NetworkError.
+func IsNetwork(err error) bool {
+	return errors.Is(err, &NetworkError{})
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.cause
+}
@@ -0,0 +1,99 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// tagsRefSpec fetches all the tags and updates the moved ones.
+const tagsRefSpec = config.RefSpec("+refs/tags/*:refs/tags/*")
+
+// fetch fetches the remote with the options. It is not an error when the
+// remote has nothing new.
+func (r *Repo) fetch(ctx context.Context, repo *git.Repository, remote repoRemote, opts *git.FetchOptions) error {
+	ctx, span := r.startSpan(ctx, SpanFetch, slog.String("remote", remote.name), slog.Int("depth", opts.Depth))
+
+	err := repo.FetchContext(ctx, opts)
+	err = contextError(ctx, "fetch", err)
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		err = nil
+	} else if err != nil {
+		// RepositoryNotFoundError could happen if the repository was removed from the remote after it
+		// was cloned. In that case Fetch will be the first to realise that repo does not exist since
+		// Clone only performs an Open.
+		err = transportError(remote, err)
+	}
+
+	span.End(err)
+	if err != nil {
+		return err
+	}
+
+	r.logger.DebugContext(ctx, "fetched remote", slog.String("remote", remote.name), slog.Int("depth", opts.Depth))
+
+	return nil
+}
+
+// fetchOptions returns options used by EnsureUpToDate to fetch the remote of
+// an existing clone. Config.FetchTagsOnly and Config.SingleBranch apply to the
+// primary remote only.
+//
+// Tags of all the remotes are fetched into the same namespace. To keep
+// pruning of one remote from deleting tags of the others, tags are fetched
+// without pruning in a separate request when additional remotes are
+// configured.
+func (r *Repo) fetchOptions(repo *git.Repository, remote repoRemote) ([]*git.FetchOptions, error) {
+	newOpts := func(refSpecs []config.RefSpec, prune bool) *git.FetchOptions {
+		return &git.FetchOptions{
+			RemoteName: remote.name,
+			RefSpecs:   refSpecs,
+			Auth:       remote.auth,
+			Depth:      r.cloneDepth,
+			Force:      true,
+			Prune:      prune,
+			Progress:   r.progress,
+		}
+	}
+
+	if r.fetchTagsOnly && remote.name == r.remoteName {
+		return []*git.FetchOptions{newOpts([]config.RefSpec{tagsRefSpec}, r.prune && len(r.remotes) == 0)}, nil
+	}
+
+	branchRefSpecs, err := r.branchRefSpecs(repo, remote.name)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.prune && len(r.remotes) > 0 {
+		opts := []*git.FetchOptions{
+			newOpts(branchRefSpecs, true),
+			newOpts([]config.RefSpec{tagsRefSpec}, false),
+		}
+
+		return opts, nil
+	}
+
+	return []*git.FetchOptions{newOpts(append(branchRefSpecs, tagsRefSpec), r.prune)}, nil
+}
+
+// branchRefSpecs returns refspecs fetching branches of the remote. Unless
+// Config.SingleBranch is set for the primary remote these are the ones stored
+// in the remote config.
+func (r *Repo) branchRefSpecs(repo *git.Repository, remoteName string) ([]config.RefSpec, error) {
+	if r.singleBranch != "" && remoteName == r.remoteName {
+		refSpec := config.RefSpec(fmt.Sprintf("+refs/heads/%[1]s:refs/remotes/%[2]s/%[1]s", r.singleBranch, remoteName))
+		return []config.RefSpec{refSpec}, nil
+	}
+
+	remote, err := repo.Remote(remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]config.RefSpec(nil), remote.Config().Fetch...), nil
+}
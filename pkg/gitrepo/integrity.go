@@ -0,0 +1,83 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// checkIntegrity checks that the existing repository is complete. The
+// objects HEAD and all the references point to must be stored, which is not
+// the case e.g. after an interrupted clone. It returns
+// RepositoryCorruptedError when the check fails.
+func (r *Repo) checkIntegrity(ctx context.Context, repo *git.Repository) error {
+	head, err := repo.Head()
+	if err != nil {
+		return &RepositoryCorruptedError{message: fmt.Sprintf("failed to resolve HEAD with error %#q", err), cause: err}
+	}
+	err = repo.Storer.HasEncodedObject(head.Hash())
+	if err != nil {
+		return &RepositoryCorruptedError{message: fmt.Sprintf("HEAD points to missing object %s", head.Hash()), cause: err}
+	}
+
+	iter, err := repo.Storer.IterReferences()
+	if err != nil {
+		return &RepositoryCorruptedError{message: fmt.Sprintf("failed to read references with error %#q", err), cause: err}
+	}
+	defer iter.Close()
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		err := checkContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		err = repo.Storer.HasEncodedObject(ref.Hash())
+		if err != nil {
+			return &RepositoryCorruptedError{message: fmt.Sprintf("reference %#q points to missing object %s", ref.Name(), ref.Hash()), cause: err}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// reset removes the repository so it is cloned again. For repositories
+// stored in Config.Dir the whole directory is removed unless keepWorktree is
+// set, in which case only the .git directory is.
+func (r *Repo) reset(keepWorktree bool) error {
+	switch {
+	case r.dir != "":
+		dir := r.dir
+		if keepWorktree {
+			dir = filepath.Join(r.dir, ".git")
+		}
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return err
+		}
+		r.storage, r.worktree = newDirStorage(r.dir)
+	case r.inMemory:
+		r.storage, r.worktree = memory.NewStorage(), memfs.New()
+	default:
+		return &ExecutionFailedError{message: "resetting custom storage is not supported"}
+	}
+
+	r.repo = nil
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package gitrepo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Test_Repo_EnsureUpToDate_corrupted tests that EnsureUpToDate detects
+// incomplete repositories and recovers them by cloning again when configured.
+func Test_Repo_EnsureUpToDate_corrupted(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		corrupt       func(t *testing.T, dir string) string
+		recover       bool
+		expectedError error
+	}{
+		{
+			name:    "case 0: intact repository",
+			corrupt: func(t *testing.T, dir string) string { return "" },
+		},
+		{
+			name:          "case 1: missing objects",
+			corrupt:       removeObjects,
+			expectedError: &RepositoryCorruptedError{},
+		},
+		{
+			name:    "case 2: missing objects recovered",
+			corrupt: removeObjects,
+			recover: true,
+		},
+		{
+			name: "case 3: HEAD points to missing branch",
+			corrupt: func(t *testing.T, dir string) string {
+				err := os.Remove(filepath.Join(dir, ".git", "refs", "heads", "master"))
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+				return ""
+			},
+			expectedError: &RepositoryCorruptedError{},
+		},
+		{
+			name: "case 4: cloned from another URL is not recovered",
+			corrupt: func(t *testing.T, dir string) string {
+				other := newTestOrigin(t)
+				other.branch("master", other.commit(nil, nil, "other", t0))
+				return other.dir
+			},
+			recover:       true,
+			expectedError: &RemoteURLMismatchError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+			origin.tag("v1.0.0", c0)
+			origin.branch("master", c0)
+
+			repo := origin.clone(Config{})
+			dir := repo.dir
+			url := origin.dir
+			if u := tc.corrupt(t, dir); u != "" {
+				url = u
+			}
+
+			repo, err := New(Config{Dir: dir, URL: url, RecoverCorrupted: tc.recover})
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			report, err := repo.EnsureUpToDateWithReport(ctx)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("err = %v, want %v", err, tc.expectedError)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+
+			if report.Recovered != tc.recover || report.Cloned != tc.recover {
+				t.Fatalf("report = %#v, want recovered and cloned %v", report, tc.recover)
+			}
+			if tc.recover && !errors.Is(report.RecoveredFrom, &RepositoryCorruptedError{}) {
+				t.Fatalf("err = %v, want %v", report.RecoveredFrom, &RepositoryCorruptedError{})
+			}
+
+			sha, err := repo.HeadSHA(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if sha != c0.String() {
+				t.Fatalf("sha = %s, want %s", sha, c0)
+			}
+
+			content, err := os.ReadFile(filepath.Join(dir, "file"))
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if string(content) != "c0" {
+				t.Fatalf("content = %q, want %q", content, "c0")
+			}
+		})
+	}
+}
+
+// Test_Repo_EnsureUpToDate_failedClone tests that a failed clone does not
+// leave a repository behind.
+func Test_Repo_EnsureUpToDate_failedClone(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "case 0: directory",
+			config: Config{Dir: filepath.Join(t.TempDir(), "clone")},
+		},
+		{
+			name:   "case 1: in memory",
+			config: Config{InMemory: true},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			c := tc.config
+			c.URL = filepath.Join(t.TempDir(), "does-not-exist")
+
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			for j := 0; j < 2; j++ {
+				err = repo.EnsureUpToDate(context.Background())
+				if !errors.Is(err, &RepositoryNotFoundError{}) {
+					t.Fatalf("err = %v, want %v", err, &RepositoryNotFoundError{})
+				}
+			}
+
+			_, err = repo.storage.Reference(plumbing.HEAD)
+			if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+				t.Fatalf("err = %v, want %v", err, plumbing.ErrReferenceNotFound)
+			}
+			if c.Dir != "" {
+				_, err = os.Stat(c.Dir)
+				if !os.IsNotExist(err) {
+					t.Fatalf("err = %v, want %v", err, os.ErrNotExist)
+				}
+			}
+		})
+	}
+}
+
+// removeObjects removes all the objects of the repository in dir as if the
+// clone was interrupted.
+func removeObjects(t *testing.T, dir string) string {
+	t.Helper()
+
+	err := os.RemoveAll(filepath.Join(dir, ".git", "objects"))
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	return ""
+}
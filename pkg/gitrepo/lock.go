@@ -0,0 +1,255 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+const (
+	defaultLockTimeout    = 5 * time.Minute
+	defaultLockStaleAfter = time.Minute
+
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// fileLock is an advisory lock of a repository directory shared by multiple
+// processes. The lock is a file created exclusively next to the directory. It
+// holds the host name and PID of the owner, and its modification time is
+// refreshed while the lock is held.
+//
+// The lock is stale when the owner runs on the same host and the process does
+// not exist anymore, or when the modification time was not refreshed for
+// staleAfter, e.g. because the owner runs in another container sharing the
+// volume and was killed.
+type fileLock struct {
+	path       string
+	timeout    time.Duration
+	staleAfter time.Duration
+}
+
+func newFileLock(dir string, timeout, staleAfter time.Duration) *fileLock {
+	if timeout == 0 {
+		timeout = defaultLockTimeout
+	}
+	if staleAfter == 0 {
+		staleAfter = defaultLockStaleAfter
+	}
+
+	l := &fileLock{
+		path:       filepath.Clean(dir) + ".lock",
+		timeout:    timeout,
+		staleAfter: staleAfter,
+	}
+
+	return l
+}
+
+// acquire waits until the lock is obtained and returns the function releasing
+// it. It returns RepositoryLockedError when the lock is not obtained within
+// the timeout.
+func (l *fileLock) acquire(ctx context.Context) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(l.path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(l.timeout)
+	for {
+		err := l.create()
+		if err == nil {
+			return l.hold(), nil
+		} else if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		owner, modTime, stale, err := l.stale()
+		if err != nil {
+			return nil, err
+		}
+		if stale {
+			err = l.removeStale(modTime)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, &RepositoryLockedError{message: fmt.Sprintf("failed to obtain lock %#q held by %#q within %s", l.path, owner, l.timeout)}
+		}
+
+		timer := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, checkContext(ctx)
+		case <-timer.C:
+		}
+	}
+}
+
+// acquireLock acquires the lock of the repository directory when
+// Config.Lock is set. Other processes may have written the repository while
+// the lock was not held, so the packfile index of the storage is reloaded.
+func (r *Repo) acquireLock(ctx context.Context) (func(), error) {
+	if r.lock == nil {
+		return func() {}, nil
+	}
+
+	unlock, err := r.lock.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := r.storage.(interface{ Reindex() }); ok {
+		s.Reindex()
+	}
+
+	return unlock, nil
+}
+
+// create creates the lock file. It fails with os.ErrExist when the lock is
+// held.
+func (l *fileLock) create() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	_, err = fmt.Fprintf(f, "%s %d\n", hostname, os.Getpid())
+	if err != nil {
+		f.Close()
+		os.Remove(l.path)
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(l.path)
+		return err
+	}
+
+	return nil
+}
+
+// hold refreshes the modification time of the lock file until the returned
+// release function is called.
+func (l *fileLock) hold() func() {
+	doneCh := make(chan struct{})
+	stoppedCh := make(chan struct{})
+
+	go func() {
+		defer close(stoppedCh)
+
+		ticker := time.NewTicker(l.staleAfter / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-doneCh:
+				return
+			case t := <-ticker.C:
+				// Failing to refresh only makes the lock
+				// look stale earlier.
+				_ = os.Chtimes(l.path, t, t)
+			}
+		}
+	}()
+
+	return func() {
+		close(doneCh)
+		<-stoppedCh
+
+		_ = os.Remove(l.path)
+	}
+}
+
+// stale returns the owner and the modification time of the lock file, and
+// whether the lock is stale. A lock removed in the meantime is reported as
+// stale.
+func (l *fileLock) stale() (string, time.Time, bool, error) {
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return "", time.Time{}, true, nil
+	} else if err != nil {
+		return "", time.Time{}, false, err
+	}
+	modTime := info.ModTime()
+
+	content, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return "", time.Time{}, true, nil
+	} else if err != nil {
+		return "", time.Time{}, false, err
+	}
+	owner := strings.TrimSpace(string(content))
+
+	if time.Since(modTime) > l.staleAfter {
+		return owner, modTime, true, nil
+	}
+
+	// The file may still be being written by the owner.
+	host, pidString, ok := strings.Cut(owner, " ")
+	if !ok {
+		return owner, modTime, false, nil
+	}
+	pid, err := strconv.Atoi(pidString)
+	if err != nil {
+		return owner, modTime, false, nil
+	}
+
+	hostname, _ := os.Hostname()
+	if host == hostname && !processExists(pid) {
+		return owner, modTime, true, nil
+	}
+
+	return owner, modTime, false, nil
+}
+
+// removeStale removes the stale lock file unless it was modified since it was
+// found stale, e.g. because another process broke the lock and obtained it in
+// the meantime.
+func (l *fileLock) removeStale(modTime time.Time) error {
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !info.ModTime().Equal(modTime) {
+		return nil
+	}
+
+	err = os.Remove(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// processExists returns whether the process with the PID runs on this host.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess fails for processes which do not exist.
+		return true
+	}
+
+	err = p.Signal(syscall.Signal(0))
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
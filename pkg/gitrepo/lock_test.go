@@ -0,0 +1,180 @@
+package gitrepo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Test_Repo_lock tests that EnsureUpToDate waits for the lock of the
+// repository directory held by another process and breaks stale locks.
+func Test_Repo_lock(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	// Get PID of a process which does not exist anymore.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	deadPID := cmd.Process.Pid
+
+	testCases := []struct {
+		name          string
+		owner         string
+		age           time.Duration
+		expectedError error
+	}{
+		{
+			name:          "case 0: lock held by running process",
+			owner:         hostname + " " + strconv.Itoa(os.Getpid()),
+			expectedError: &RepositoryLockedError{},
+		},
+		{
+			name:  "case 1: lock held by process which does not exist",
+			owner: hostname + " " + strconv.Itoa(deadPID),
+		},
+		{
+			name:          "case 2: lock held by process on another host",
+			owner:         "other-host 1",
+			expectedError: &RepositoryLockedError{},
+		},
+		{
+			name:  "case 3: lock not refreshed by process on another host",
+			owner: "other-host 1",
+			age:   time.Hour,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, nil, "c0", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+			origin.branch("master", c0)
+
+			dir := filepath.Join(t.TempDir(), "clone")
+			repo, err := New(Config{Dir: dir, URL: origin.dir, Lock: true, LockTimeout: 200 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			lockPath := dir + ".lock"
+			err = os.WriteFile(lockPath, []byte(tc.owner+"\n"), 0644)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if tc.age != 0 {
+				modTime := time.Now().Add(-tc.age)
+				err = os.Chtimes(lockPath, modTime, modTime)
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+			}
+
+			err = repo.EnsureUpToDate(context.Background())
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Fatalf("err = %v, want %v", err, tc.expectedError)
+				}
+				// The lock of the other process is left
+				// untouched.
+				_, err = os.Stat(lockPath)
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			_, err = os.Stat(lockPath)
+			if !os.IsNotExist(err) {
+				t.Fatalf("err = %v, want %v", err, os.ErrNotExist)
+			}
+		})
+	}
+}
+
+// Test_Repo_lock_concurrent tests that Repos sharing a directory serialize
+// clones and fetches with the lock.
+func Test_Repo_lock_concurrent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	origin := newTestOrigin(t)
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1"}, "c1", t0.Add(time.Hour))
+	origin.tag("v1.0.0", c0)
+	origin.branch("master", c1)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		repo, err := New(Config{Dir: dir, URL: origin.dir, Lock: true})
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 5; j++ {
+				err := repo.EnsureUpToDate(ctx)
+				if err != nil {
+					t.Errorf("err = %v, want %v", err, nil)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err := os.Stat(dir + ".lock")
+	if !os.IsNotExist(err) {
+		t.Fatalf("err = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+// Test_fileLock_refresh tests that a held lock is refreshed so it does not
+// become stale.
+func Test_fileLock_refresh(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "repo")
+
+	l := newFileLock(dir, time.Second, 200*time.Millisecond)
+	unlock, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	defer unlock()
+
+	time.Sleep(500 * time.Millisecond)
+
+	other := newFileLock(dir, 100*time.Millisecond, 200*time.Millisecond)
+	_, err = other.acquire(context.Background())
+	if !errors.Is(err, &RepositoryLockedError{}) {
+		t.Fatalf("err = %v, want %v", err, &RepositoryLockedError{})
+	}
+}
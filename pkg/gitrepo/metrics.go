@@ -0,0 +1,208 @@
+package gitrepo
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operations measured by Metrics.
+const (
+	operationEnsureUpToDate = "EnsureUpToDate"
+	operationResolveVersion = "ResolveVersion"
+	operationGetFileContent = "GetFileContent"
+)
+
+// sizeInterval is the minimum time between measurements of the repository
+// size of a URL.
+const sizeInterval = 5 * time.Minute
+
+// errorTypes are the errors counted by their type name. Other errors are
+// counted as "other".
+var errorTypes = []error{
+	&ExecutionFailedError{},
+	&InvalidConfigError{},
+	&FileNotFoundError{},
+	&FolderNotFoundError{},
+	&ReferenceNotFoundError{},
+	&RepositoryNotFoundError{},
+	&CanceledError{},
+	&TagAlreadyExistsError{},
+	&ShallowHistoryError{},
+	&RepositoryLockedError{},
+	&RepositoryCorruptedError{},
+	&RemoteURLMismatchError{},
+	&AuthenticationFailedError{},
+	&AuthorizationFailedError{},
+	&NetworkError{},
+}
+
+// Metrics are Prometheus metrics of repository operations. A single Metrics
+// is meant to be shared by all the Repos of the process through
+// Config.Metrics. Series are labeled with the repository URL with the
+// password redacted.
+//
+// The "operation" label is one of "EnsureUpToDate", "ResolveVersion" and
+// "GetFileContent". The "error" label is the name of the
+// returned error type, e.g. "NetworkError", or "other".
+type Metrics struct {
+	duration       *prometheus.HistogramVec
+	errors         *prometheus.CounterVec
+	fetchedBytes   *prometheus.CounterVec
+	fetchedObjects *prometheus.CounterVec
+	size           *prometheus.GaugeVec
+
+	// sizeMu guards sizeMeasured, the time of the last size measurement
+	// by URL.
+	sizeMu       sync.Mutex
+	sizeMeasured map[string]time.Time
+}
+
+// NewMetrics creates the metrics and registers them with the registerer.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	if registerer == nil {
+		return nil, &InvalidConfigError{message: "registerer must not be nil"}
+	}
+
+	m := &Metrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gitrepo",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of repository operations.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+		}, []string{"url", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gitrepo",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed repository operations by error type.",
+		}, []string{"url", "operation", "error"}),
+		fetchedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gitrepo",
+			Name:      "fetched_bytes_total",
+			Help:      "Size of packfiles received by clones and fetches.",
+		}, []string{"url"}),
+		fetchedObjects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gitrepo",
+			Name:      "fetched_objects_total",
+			Help:      "Number of objects received by clones and fetches.",
+		}, []string{"url"}),
+		size: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gitrepo",
+			Name:      "repository_size_bytes",
+			Help:      "Size of the .git directory on disk measured after updates at most every 5 minutes.",
+		}, []string{"url"}),
+		sizeMeasured: map[string]time.Time{},
+	}
+
+	for _, c := range []prometheus.Collector{m.duration, m.errors, m.fetchedBytes, m.fetchedObjects, m.size} {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("failed to register metrics with error %#q", err)}
+		}
+	}
+
+	return m, nil
+}
+
+// observe records the duration and the error of the operation started at
+// start. It does nothing when m is nil.
+func (m *Metrics) observe(url, operation string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.duration.WithLabelValues(url, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(url, operation, errorType(err)).Inc()
+	}
+}
+
+// observeUpdate records the data received by the update. It does nothing
+// when m is nil.
+func (m *Metrics) observeUpdate(url string, report *UpdateReport) {
+	if m == nil {
+		return
+	}
+
+	m.fetchedBytes.WithLabelValues(url).Add(float64(report.Transfer.Bytes))
+	m.fetchedObjects.WithLabelValues(url).Add(float64(report.Transfer.Objects))
+}
+
+// observeSize records the size of the .git directory of the repository
+// stored in dir unless it was measured less than sizeInterval ago. It does
+// nothing when m is nil or dir is empty. It walks the directory so it must
+// not be called with the repository locked.
+func (m *Metrics) observeSize(url, dir string) error {
+	if m == nil || dir == "" {
+		return nil
+	}
+
+	{
+		m.sizeMu.Lock()
+		last, ok := m.sizeMeasured[url]
+		now := time.Now()
+		if ok && now.Sub(last) < sizeInterval {
+			m.sizeMu.Unlock()
+			return nil
+		}
+		m.sizeMeasured[url] = now
+		m.sizeMu.Unlock()
+	}
+
+	size, err := dirSize(filepath.Join(dir, ".git"))
+	if err != nil {
+		return err
+	}
+	m.size.WithLabelValues(url).Set(float64(size))
+
+	return nil
+}
+
+// errorType returns the name of the exported error type err matches or
+// "other".
+func errorType(err error) string {
+	for _, e := range errorTypes {
+		if errors.Is(err, e) {
+			return reflect.TypeOf(e).Elem().Name()
+		}
+	}
+
+	return "other"
+}
+
+// dirSize returns the total size of the files in dir. Files removed during
+// the walk, e.g. by a concurrent gc, are skipped.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path != dir {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		size += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
@@ -0,0 +1,214 @@
+package gitrepo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Test_Repo_metrics tests that operations and fetched data are recorded in
+// the registry.
+func Test_Repo_metrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	origin := newTestOrigin(t)
+	c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+	origin.tag("v1.0.0", c0)
+	origin.branch("master", c0)
+
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	repo, err := New(Config{
+		Dir:     filepath.Join(t.TempDir(), "clone"),
+		URL:     origin.dir,
+		Metrics: metrics,
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	err = repo.EnsureUpToDate(ctx)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	_, err = repo.ResolveVersion(ctx, "master")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	_, err = repo.GetFileContent("missing", "master")
+	if !errors.Is(err, &FileNotFoundError{}) {
+		t.Fatalf("err = %v, want %v", err, &FileNotFoundError{})
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	metricsByName := map[string][]*dto.Metric{}
+	for _, f := range families {
+		metricsByName[f.GetName()] = f.GetMetric()
+	}
+
+	operations := map[string]uint64{}
+	for _, m := range metricsByName["gitrepo_operation_duration_seconds"] {
+		if label(m, "url") != origin.dir {
+			t.Fatalf("url = %q, want %q", label(m, "url"), origin.dir)
+		}
+		operations[label(m, "operation")] = m.GetHistogram().GetSampleCount()
+	}
+	for _, op := range []string{"EnsureUpToDate", "ResolveVersion", "GetFileContent"} {
+		if operations[op] != 1 {
+			t.Fatalf("operation %q: count = %d, want %d", op, operations[op], 1)
+		}
+	}
+
+	errs := metricsByName["gitrepo_operation_errors_total"]
+	if len(errs) != 1 || label(errs[0], "operation") != "GetFileContent" || label(errs[0], "error") != "FileNotFoundError" || errs[0].GetCounter().GetValue() != 1 {
+		t.Fatalf("errors = %v, want one GetFileContent FileNotFoundError", errs)
+	}
+
+	// Commit, tree and blob are fetched by the clone.
+	if v := single(t, metricsByName, "gitrepo_fetched_objects_total").GetCounter().GetValue(); v != 3 {
+		t.Fatalf("fetched objects = %v, want %v", v, 3)
+	}
+	if v := single(t, metricsByName, "gitrepo_fetched_bytes_total").GetCounter().GetValue(); v <= 0 {
+		t.Fatalf("fetched bytes = %v, want positive", v)
+	}
+	if v := single(t, metricsByName, "gitrepo_repository_size_bytes").GetGauge().GetValue(); v <= 0 {
+		t.Fatalf("repository size = %v, want positive", v)
+	}
+
+	_, err = NewMetrics(registry)
+	if !errors.Is(err, &InvalidConfigError{}) {
+		t.Fatalf("err = %v, want %v", err, &InvalidConfigError{})
+	}
+}
+
+// Test_Metrics_observeSize tests that only the .git directory is measured and
+// measurements are throttled.
+func Test_Metrics_observeSize(t *testing.T) {
+	t.Parallel()
+
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	dir := t.TempDir()
+	err = os.Mkdir(filepath.Join(dir, ".git"), 0o755)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	for name, size := range map[string]int{".git/pack": 10, "worktree-file": 100} {
+		err = os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o600)
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+	}
+
+	err = metrics.observeSize("url", dir)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	if v := gaugeValue(t, metrics.size.WithLabelValues("url")); v != 10 {
+		t.Fatalf("size = %v, want %v", v, 10)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, ".git", "pack"), make([]byte, 20), 0o600)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	err = metrics.observeSize("url", dir)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	if v := gaugeValue(t, metrics.size.WithLabelValues("url")); v != 10 {
+		t.Fatalf("size = %v, want %v", v, 10)
+	}
+}
+
+func Test_errorType(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		err          error
+		expectedType string
+	}{
+		{
+			name:         "case 0: exported error type",
+			err:          &NetworkError{},
+			expectedType: "NetworkError",
+		},
+		{
+			name:         "case 1: canceled",
+			err:          &CanceledError{message: "canceled", cause: context.Canceled},
+			expectedType: "CanceledError",
+		},
+		{
+			name:         "case 2: other error",
+			err:          errors.New("unknown"),
+			expectedType: "other",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			errType := errorType(tc.err)
+			if errType != tc.expectedType {
+				t.Fatalf("type = %q, want %q", errType, tc.expectedType)
+			}
+		})
+	}
+}
+
+// label returns the value of the label of the metric.
+func label(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+
+	return ""
+}
+
+// gaugeValue returns the value of the gauge.
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	err := g.Write(&m)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	return m.GetGauge().GetValue()
+}
+
+// single returns the only metric with the name.
+func single(t *testing.T, metricsByName map[string][]*dto.Metric, name string) *dto.Metric {
+	t.Helper()
+
+	if len(metricsByName[name]) != 1 {
+		t.Fatalf("metric %q: len = %d, want %d", name, len(metricsByName[name]), 1)
+	}
+
+	return metricsByName[name][0]
+}
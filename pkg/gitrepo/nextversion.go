@@ -0,0 +1,331 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/mod/semver"
+)
+
+// conventionalCommitRegex matches Conventional Commits header, e.g.
+// "feat(api)!: add endpoint". See https://www.conventionalcommits.org.
+var conventionalCommitRegex = regexp.MustCompile(`^(?P<type>[a-zA-Z]+)(?:\((?P<scope>[^()]*)\))?(?P<breaking>!)?: \S`)
+
+// breakingChangeRegex matches breaking change footer of Conventional Commits.
+var breakingChangeRegex = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: `)
+
+// preReleaseChannelRegex matches valid pre-release channel names. They must
+// not contain "." so the pre-release number can be parsed back.
+var preReleaseChannelRegex = regexp.MustCompile(`^[0-9A-Za-z-]*[A-Za-z-][0-9A-Za-z-]*$`)
+
+// Bump is a semantic version increment.
+type Bump int
+
+const (
+	BumpNone Bump = iota
+	BumpPatch
+	BumpMinor
+	BumpMajor
+)
+
+func (b Bump) String() string {
+	switch b {
+	case BumpPatch:
+		return "patch"
+	case BumpMinor:
+		return "minor"
+	case BumpMajor:
+		return "major"
+	default:
+		return "none"
+	}
+}
+
+// BumpCommit is a commit following Conventional Commits which requires
+// a version bump.
+type BumpCommit struct {
+	SHA string
+	// Subject is the first line of the commit message.
+	Subject string
+	// Type is the Conventional Commits type, e.g. "feat" or "fix".
+	Type string
+	// Breaking is true when the commit is marked as a breaking change with
+	// "!" or a "BREAKING CHANGE" footer.
+	Breaking bool
+	Bump     Bump
+}
+
+// NextVersionResult is the result of Repo.NextVersion.
+type NextVersionResult struct {
+	// Version is the next version. It is formatted the same way as
+	// tagged versions returned by ResolveVersion, e.g. "1.3.0".
+	Version string
+	// PreviousVersion is the version of the last version tag or empty
+	// when there is no such tag.
+	PreviousVersion string
+	// PreviousSHA is the SHA of the commit tagged with the last version
+	// tag or empty when there is no such tag.
+	PreviousSHA string
+	// Bump is the highest bump required by Commits.
+	Bump Bump
+	// Commits are the commits since the last version tag which require
+	// a version bump ordered by committer date in descending order.
+	Commits []BumpCommit
+}
+
+// NextVersion computes the next release version of a reference. The last
+// version tag is found with the same ancestry logic as ResolveVersion,
+// including the tag prefix, version tag pattern, version paths and version
+// strategy. Then messages of the commits since that tag are scanned for
+// Conventional Commits markers. "fix:" requires a patch bump, "feat:" a minor
+// bump and "!" after the type or a "BREAKING CHANGE:" footer a major bump. When
+// there is no version tag the commits are applied to "0.0.0".
+//
+// When Config.PreReleaseChannel is set, the next version is a pre-release on
+// that channel, e.g. "1.3.0-rc.1". Subsequent pre-releases of the same
+// version increment the pre-release number, e.g. "1.3.0-rc.2". When the
+// channel is not set and the last version tag is a pre-release, the next
+// version is its release, e.g. "1.3.0" for "1.3.0-rc.2". In both cases the
+// version of the pre-release is bumped only when the commits require a higher
+// bump than the one already done for the pre-release.
+//
+// When no commit requires a bump, Version is the same as PreviousVersion and
+// Bump is BumpNone.
+//
+// Same as in ResolveVersion, the history of shallow clones is deepened on
+// demand.
+func (r *Repo) NextVersion(ctx context.Context, ref string) (*NextVersionResult, error) {
+	err := checkContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	var result *NextVersionResult
+	err = r.withDeepening(ctx, repo, func() error {
+		var err error
+		result, err = r.computeNextVersion(ctx, repo, ref)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *Repo) computeNextVersion(ctx context.Context, repo *git.Repository, ref string) (*NextVersionResult, error) {
+	versionsByHash, err := r.versionsByHash(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := r.resolveCommit(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// With path-scoped versioning the base version is looked up the same
+	// way as in ResolveVersion. Commits not touching the paths are skipped
+	// by bumpCommits.
+	_, previous, previousHash, err := r.versionBase(ctx, repo, commit, versionsByHash)
+	if err != nil {
+		return nil, err
+	}
+
+	commits, err := r.bumpCommits(ctx, repo, commit, previousHash)
+	if err != nil {
+		return nil, err
+	}
+
+	bump := BumpNone
+	for _, c := range commits {
+		if c.Bump > bump {
+			bump = c.Bump
+		}
+	}
+
+	version := previous
+	if version == "" {
+		version = "0.0.0"
+	}
+	if bump != BumpNone {
+		version, err = nextVersion(version, bump, r.preReleaseChannel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	version, err = formatVersion(r.versionFormat, version)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &NextVersionResult{
+		Version:         version,
+		PreviousVersion: previous,
+		Bump:            bump,
+		Commits:         commits,
+	}
+	if previous != "" {
+		result.PreviousSHA = previousHash.String()
+	}
+
+	return result, nil
+}
+
+// bumpCommits returns commits reachable from commit, but not from the commit
+// with hash since, which require a version bump. When version paths are
+// configured only commits touching them are considered.
+func (r *Repo) bumpCommits(ctx context.Context, repo *git.Repository, commit *object.Commit, since plumbing.Hash) ([]BumpCommit, error) {
+	// Collect all the commits already released with the since commit.
+	seen := map[plumbing.Hash]bool{}
+	if !since.IsZero() {
+		var err error
+		seen, err = reachableCommits(ctx, repo, since)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var commits []BumpCommit
+
+	iter := object.NewCommitIterCTime(commit, seen, nil)
+	defer iter.Close()
+
+	err := iter.ForEach(func(c *object.Commit) error {
+		err := checkContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		bc, ok := parseConventionalCommit(c)
+		if !ok || bc.Bump == BumpNone {
+			return nil
+		}
+
+		if len(r.versionPaths) > 0 {
+			touches, err := touchesPaths(c, r.versionPaths)
+			if err != nil {
+				return err
+			}
+			if !touches {
+				return nil
+			}
+		}
+
+		commits = append(commits, bc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return commits, nil
+}
+
+// parseConventionalCommit parses the commit message following Conventional
+// Commits. It returns false if the message does not follow it.
+func parseConventionalCommit(c *object.Commit) (BumpCommit, bool) {
+	subject, body, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+
+	match := conventionalCommitRegex.FindStringSubmatch(subject)
+	if match == nil {
+		return BumpCommit{}, false
+	}
+
+	bc := BumpCommit{
+		SHA:      c.Hash.String(),
+		Subject:  subject,
+		Type:     strings.ToLower(match[conventionalCommitRegex.SubexpIndex("type")]),
+		Breaking: match[conventionalCommitRegex.SubexpIndex("breaking")] != "" || breakingChangeRegex.MatchString(body),
+	}
+
+	switch {
+	case bc.Breaking:
+		bc.Bump = BumpMajor
+	case bc.Type == "feat":
+		bc.Bump = BumpMinor
+	case bc.Type == "fix":
+		bc.Bump = BumpPatch
+	}
+
+	return bc, true
+}
+
+// nextVersion bumps the version in format "X.Y.Z[-pre]". See
+// Repo.NextVersion for details.
+func nextVersion(version string, bump Bump, channel string) (string, error) {
+	v := "v" + version
+	if !semver.IsValid(v) {
+		return "", &ExecutionFailedError{message: fmt.Sprintf("version %#q is not a valid semantic version", version)}
+	}
+
+	pre := strings.TrimPrefix(semver.Prerelease(v), "-")
+	core := strings.TrimPrefix(strings.TrimSuffix(semver.Canonical(v), semver.Prerelease(v)), "v")
+
+	var major, minor, patch int
+	{
+		parts := strings.Split(core, ".")
+		major, _ = strconv.Atoi(parts[0])
+		minor, _ = strconv.Atoi(parts[1])
+		patch, _ = strconv.Atoi(parts[2])
+	}
+
+	// The version of a pre-release is not released yet. It already
+	// contains the bump done when the pre-release was created so bump it
+	// again only when higher bump is required.
+	doneBump := BumpNone
+	if pre != "" {
+		switch {
+		case minor == 0 && patch == 0:
+			doneBump = BumpMajor
+		case patch == 0:
+			doneBump = BumpMinor
+		default:
+			doneBump = BumpPatch
+		}
+	}
+
+	next := core
+	if bump > doneBump {
+		switch bump {
+		case BumpMajor:
+			next = fmt.Sprintf("%d.0.0", major+1)
+		case BumpMinor:
+			next = fmt.Sprintf("%d.%d.0", major, minor+1)
+		case BumpPatch:
+			next = fmt.Sprintf("%d.%d.%d", major, minor, patch+1)
+		}
+	}
+
+	if channel == "" {
+		return next, nil
+	}
+
+	number := 1
+	if next == core {
+		preChannel, preNumber, ok := strings.Cut(pre, ".")
+		if preChannel == channel {
+			n, err := strconv.Atoi(preNumber)
+			if ok && err == nil {
+				number = n + 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s-%s.%d", next, channel, number), nil
+}
@@ -0,0 +1,333 @@
+package gitrepo
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Test_Repo_NextVersion tests Repo.NextVersion method against a local
+// repository.
+func Test_Repo_NextVersion(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestOrigin(t)
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, nil, "feat: initial commit", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, nil, "chore: release", t0.Add(1*time.Hour))
+	c2 := origin.commit([]plumbing.Hash{c1}, nil, "fix: fix a bug", t0.Add(2*time.Hour))
+	c3 := origin.commit([]plumbing.Hash{c2}, nil, "docs: update readme", t0.Add(3*time.Hour))
+	c4 := origin.commit([]plumbing.Hash{c3}, nil, "feat(api): add endpoint", t0.Add(4*time.Hour))
+	c5 := origin.commit([]plumbing.Hash{c4}, nil, "refactor: rework api\n\nBREAKING CHANGE: endpoint removed", t0.Add(5*time.Hour))
+	m1 := origin.commit([]plumbing.Hash{c1}, map[string]string{"module-a/x": "1"}, "feat!: drop support", t0.Add(6*time.Hour))
+	m2 := origin.commit([]plumbing.Hash{m1}, map[string]string{"module-b/y": "1"}, "fix: fix module-b", t0.Add(7*time.Hour))
+	r1 := origin.commit([]plumbing.Hash{c1}, nil, "fix: fix rc", t0.Add(8*time.Hour))
+	a1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"module-a/x": "1"}, "feat: x", t0.Add(9*time.Hour))
+	a2 := origin.commit([]plumbing.Hash{a1}, map[string]string{"CHANGELOG.md": "1"}, "chore: release module-a", t0.Add(10*time.Hour))
+	origin.tag("v1.2.3", c1)
+	origin.tag("module-b/v0.1.0", c1)
+	origin.tag("module-a/v0.9.0", c0)
+	origin.tag("module-a/v1.0.0", a2)
+	origin.tag("v1.3.0-rc.1", c4)
+	origin.branch("modules", m2)
+	origin.branch("rc", r1)
+	origin.branch("module-a", a2)
+	origin.branch("master", c5)
+
+	testCases := []struct {
+		name             string
+		config           Config
+		ref              string
+		expectedVersion  string
+		expectedPrevious string
+		expectedBump     Bump
+		expectedCommits  []plumbing.Hash
+	}{
+		{
+			name:             "case 0: no bump since tag",
+			ref:              c1.String(),
+			expectedVersion:  "1.2.3",
+			expectedPrevious: "1.2.3",
+			expectedBump:     BumpNone,
+		},
+		{
+			name:             "case 1: fix since tag",
+			ref:              c3.String(),
+			expectedVersion:  "1.2.4",
+			expectedPrevious: "1.2.3",
+			expectedBump:     BumpPatch,
+			expectedCommits:  []plumbing.Hash{c2},
+		},
+		{
+			name:             "case 2: pre-release tagged commit released",
+			ref:              c4.String(),
+			expectedVersion:  "1.3.0-rc.1",
+			expectedPrevious: "1.3.0-rc.1",
+			expectedBump:     BumpNone,
+		},
+		{
+			name:             "case 3: breaking change since pre-release",
+			ref:              "master",
+			expectedVersion:  "2.0.0",
+			expectedPrevious: "1.3.0-rc.1",
+			expectedBump:     BumpMajor,
+			expectedCommits:  []plumbing.Hash{c5},
+		},
+		{
+			name:             "case 4: breaking change on pre-release channel",
+			config:           Config{PreReleaseChannel: "rc"},
+			ref:              "master",
+			expectedVersion:  "2.0.0-rc.1",
+			expectedPrevious: "1.3.0-rc.1",
+			expectedBump:     BumpMajor,
+			expectedCommits:  []plumbing.Hash{c5},
+		},
+		{
+			name:             "case 5: fix on pre-release channel",
+			config:           Config{PreReleaseChannel: "rc"},
+			ref:              "origin/rc",
+			expectedVersion:  "1.2.4-rc.1",
+			expectedPrevious: "1.2.3",
+			expectedBump:     BumpPatch,
+			expectedCommits:  []plumbing.Hash{r1},
+		},
+		{
+			name:            "case 6: no tag",
+			ref:             c0.String(),
+			expectedVersion: "0.1.0",
+			expectedBump:    BumpMinor,
+			expectedCommits: []plumbing.Hash{c0},
+		},
+		{
+			name:             "case 7: module with tag prefix and path",
+			config:           Config{TagPrefix: "module-b", VersionPaths: []string{"module-b"}},
+			ref:              "origin/modules",
+			expectedVersion:  "0.1.1",
+			expectedPrevious: "0.1.0",
+			expectedBump:     BumpPatch,
+			expectedCommits:  []plumbing.Hash{m2},
+		},
+		{
+			name:             "case 8: go format",
+			config:           Config{VersionFormat: VersionFormatGo},
+			ref:              "origin/modules",
+			expectedVersion:  "v2.0.0",
+			expectedPrevious: "1.2.3",
+			expectedBump:     BumpMajor,
+			expectedCommits:  []plumbing.Hash{m2, m1},
+		},
+		{
+			name:             "case 9: module tag on commit not touching the path",
+			config:           Config{TagPrefix: "module-a", VersionPaths: []string{"module-a"}},
+			ref:              "origin/module-a",
+			expectedVersion:  "1.0.0",
+			expectedPrevious: "1.0.0",
+			expectedBump:     BumpNone,
+		},
+	}
+
+	dir := origin.clone(Config{}).worktree.Root()
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			c := tc.config
+			c.Dir = dir
+
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			result, err := repo.NextVersion(context.Background(), tc.ref)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			if result.Version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", result.Version, tc.expectedVersion)
+			}
+			if result.PreviousVersion != tc.expectedPrevious {
+				t.Fatalf("previous version = %q, want %q", result.PreviousVersion, tc.expectedPrevious)
+			}
+			if result.Bump != tc.expectedBump {
+				t.Fatalf("bump = %v, want %v", result.Bump, tc.expectedBump)
+			}
+
+			var commits []plumbing.Hash
+			for _, c := range result.Commits {
+				commits = append(commits, plumbing.NewHash(c.SHA))
+			}
+			if !reflect.DeepEqual(commits, tc.expectedCommits) {
+				t.Fatalf("commits = %v, want %v", commits, tc.expectedCommits)
+			}
+		})
+	}
+}
+
+func Test_parseConventionalCommit(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		message        string
+		expectedCommit BumpCommit
+		expectedOK     bool
+	}{
+		{
+			name:           "case 0: fix",
+			message:        "fix: fix a bug\n",
+			expectedCommit: BumpCommit{Subject: "fix: fix a bug", Type: "fix", Bump: BumpPatch},
+			expectedOK:     true,
+		},
+		{
+			name:           "case 1: feat with scope",
+			message:        "feat(api): add endpoint",
+			expectedCommit: BumpCommit{Subject: "feat(api): add endpoint", Type: "feat", Bump: BumpMinor},
+			expectedOK:     true,
+		},
+		{
+			name:           "case 2: breaking with exclamation mark",
+			message:        "chore(deps)!: drop go 1.20",
+			expectedCommit: BumpCommit{Subject: "chore(deps)!: drop go 1.20", Type: "chore", Breaking: true, Bump: BumpMajor},
+			expectedOK:     true,
+		},
+		{
+			name:           "case 3: breaking with footer",
+			message:        "feat: new api\n\nSome details.\n\nBREAKING-CHANGE: old api removed",
+			expectedCommit: BumpCommit{Subject: "feat: new api", Type: "feat", Breaking: true, Bump: BumpMajor},
+			expectedOK:     true,
+		},
+		{
+			name:           "case 4: other type",
+			message:        "docs: update readme",
+			expectedCommit: BumpCommit{Subject: "docs: update readme", Type: "docs"},
+			expectedOK:     true,
+		},
+		{
+			name:    "case 5: not conventional",
+			message: "Merge branch 'main' into feature",
+		},
+		{
+			name:    "case 6: breaking footer in subject only",
+			message: "BREAKING CHANGE: everything",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			commit, ok := parseConventionalCommit(&object.Commit{Message: tc.message})
+			if ok != tc.expectedOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.expectedOK)
+			}
+
+			tc.expectedCommit.SHA = plumbing.ZeroHash.String()
+			if ok && !reflect.DeepEqual(commit, tc.expectedCommit) {
+				t.Fatalf("commit = %#v, want %#v", commit, tc.expectedCommit)
+			}
+		})
+	}
+}
+
+func Test_nextVersion(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		version         string
+		bump            Bump
+		channel         string
+		expectedVersion string
+		expectedError   error
+	}{
+		{
+			name:            "case 0: patch",
+			version:         "1.2.3",
+			bump:            BumpPatch,
+			expectedVersion: "1.2.4",
+		},
+		{
+			name:            "case 1: minor",
+			version:         "1.2.3",
+			bump:            BumpMinor,
+			expectedVersion: "1.3.0",
+		},
+		{
+			name:            "case 2: major",
+			version:         "1.2.3+build",
+			bump:            BumpMajor,
+			expectedVersion: "2.0.0",
+		},
+		{
+			name:            "case 3: release of pre-release",
+			version:         "1.3.0-rc.2",
+			bump:            BumpMinor,
+			expectedVersion: "1.3.0",
+		},
+		{
+			name:            "case 4: higher bump than pre-release",
+			version:         "1.3.0-rc.2",
+			bump:            BumpMajor,
+			expectedVersion: "2.0.0",
+		},
+		{
+			name:            "case 5: first pre-release",
+			version:         "1.2.3",
+			bump:            BumpPatch,
+			channel:         "rc",
+			expectedVersion: "1.2.4-rc.1",
+		},
+		{
+			name:            "case 6: next pre-release",
+			version:         "1.3.0-rc.2",
+			bump:            BumpPatch,
+			channel:         "rc",
+			expectedVersion: "1.3.0-rc.3",
+		},
+		{
+			name:            "case 7: pre-release on other channel",
+			version:         "1.3.0-beta.2",
+			bump:            BumpPatch,
+			channel:         "rc",
+			expectedVersion: "1.3.0-rc.1",
+		},
+		{
+			name:            "case 8: higher bump than pre-release on channel",
+			version:         "1.3.1-rc.2",
+			bump:            BumpMinor,
+			channel:         "rc",
+			expectedVersion: "1.4.0-rc.1",
+		},
+		{
+			name:          "case 9: invalid version",
+			version:       "1.2.3.4",
+			bump:          BumpPatch,
+			expectedError: &ExecutionFailedError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			version, err := nextVersion(tc.version, tc.bump, tc.channel)
+			if reflect.TypeOf(err) != reflect.TypeOf(tc.expectedError) {
+				t.Fatalf("err = %#v, want %#v", err, tc.expectedError)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
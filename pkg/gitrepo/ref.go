@@ -0,0 +1,110 @@
+package gitrepo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// RefResolution defines how branch names passed to Repo methods are resolved.
+type RefResolution string
+
+const (
+	// RefResolutionRemoteFirst resolves branch names, e.g. "master", to
+	// the remote-tracking branch of Config.RemoteName, e.g.
+	// "refs/remotes/origin/master", when it exists. This way branch names follow the remote as fetched by
+	// EnsureUpToDate even though local branches are never advanced. The
+	// same applies to branch names in revision expressions, e.g.
+	// "master~1". Tags with the same name still take precedence, same as
+	// in git.
+	RefResolutionRemoteFirst RefResolution = ""
+	// RefResolutionLocal resolves branch names to local branches, e.g.
+	// "refs/heads/master", same as git rev-parse.
+	RefResolutionLocal RefResolution = "local"
+)
+
+func (p RefResolution) validate() error {
+	switch p {
+	case RefResolutionRemoteFirst, RefResolutionLocal:
+		return nil
+	}
+
+	return &InvalidConfigError{message: fmt.Sprintf("unknown ref resolution %#q", p)}
+}
+
+// resolveRevision resolves ref to a hash according to the configured ref
+// resolution. Full reference names, e.g. "refs/heads/master", remote-tracking
+// branches of any remote, e.g. "upstream/main", and SHAs are resolved as they
+// are. In revision expressions, e.g. "master~1", the ref resolution applies
+// to the name before the suffix. It returns error handled by
+// IsReferenceNotFound if the ref does not exist.
+func (r *Repo) resolveRevision(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	rev := ref
+	if r.refResolution == RefResolutionRemoteFirst {
+		name, suffix := splitRevision(ref)
+		if name != plumbing.HEAD.String() {
+			hash, ok, err := remoteTrackingHash(repo, r.remoteName, name)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if ok && suffix == "" {
+				return hash, nil
+			}
+			if ok {
+				rev = hash.String() + suffix
+			}
+		}
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, &ReferenceNotFoundError{Ref: ref, message: fmt.Sprintf("%#q", ref)}
+	} else if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return *hash, nil
+}
+
+// splitRevision splits the revision expression into the name and the suffix
+// starting at the first "~", "^" or "@{", e.g. "master" and "~1" for
+// "master~1".
+func splitRevision(rev string) (string, string) {
+	i := strings.IndexAny(rev, "~^")
+	if j := strings.Index(rev, "@{"); j >= 0 && (i < 0 || j < i) {
+		i = j
+	}
+	if i < 0 {
+		return rev, ""
+	}
+
+	return rev[:i], rev[i:]
+}
+
+// remoteTrackingHash returns the hash of the remote-tracking branch of the
+// remote for the branch name. It returns false if the name is not a plain branch name, there
+// is a tag with the same name or there is no such remote-tracking branch.
+func remoteTrackingHash(repo *git.Repository, remoteName, name string) (plumbing.Hash, bool, error) {
+	if plumbing.NewBranchReferenceName(name).Validate() != nil {
+		return plumbing.ZeroHash, false, nil
+	}
+
+	_, err := repo.Reference(plumbing.NewTagReferenceName(name), false)
+	if err == nil {
+		return plumbing.ZeroHash, false, nil
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, false, err
+	}
+
+	ref, err := repo.Reference(plumbing.NewRemoteReferenceName(remoteName, name), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, false, nil
+	} else if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	return ref.Hash(), true, nil
+}
@@ -0,0 +1,110 @@
+package gitrepo
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Test_Repo_refResolution tests that branch names follow the remote moved
+// between calls to EnsureUpToDate unless local ref resolution is configured.
+func Test_Repo_refResolution(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		config     Config
+		ref        string
+		expectedC2 bool
+	}{
+		{
+			name:       "case 0: branch name resolves to remote-tracking branch",
+			ref:        "master",
+			expectedC2: true,
+		},
+		{
+			name:       "case 1: empty ref resolves to remote-tracking master",
+			ref:        "",
+			expectedC2: true,
+		},
+		{
+			name: "case 2: full local branch name",
+			ref:  "refs/heads/master",
+		},
+		{
+			name:   "case 3: local ref resolution",
+			config: Config{RefResolution: RefResolutionLocal},
+			ref:    "master",
+		},
+		{
+			name: "case 4: tag with the same name takes precedence",
+			ref:  "same",
+		},
+		{
+			name:       "case 5: revision expression",
+			ref:        "origin/master~0",
+			expectedC2: true,
+		},
+		{
+			name: "case 6: revision expression of branch name resolves from remote-tracking branch",
+			ref:  "master~1",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+			c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1"}, "c1", t0.Add(time.Hour))
+			origin.tag("v1.0.0", c0)
+			origin.tag("same", c1)
+			origin.branch("same", c0)
+			origin.branch("master", c1)
+
+			repo := origin.clone(tc.config)
+
+			c2 := origin.commit([]plumbing.Hash{c1}, map[string]string{"file": "c2"}, "c2", t0.Add(2*time.Hour))
+			origin.branch("master", c2)
+
+			err := repo.EnsureUpToDate(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			expectedCommit, expectedContent := c1, "c1"
+			if tc.expectedC2 {
+				expectedCommit, expectedContent = c2, "c2"
+			}
+
+			content, err := repo.GetFileContent("file", tc.ref)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if string(content) != expectedContent {
+				t.Fatalf("content = %q, want %q", content, expectedContent)
+			}
+
+			if tc.ref == "" {
+				return
+			}
+
+			version, err := repo.ResolveVersion(ctx, tc.ref)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if expected := "1.0.0-" + expectedCommit.String(); version != expected {
+				t.Fatalf("version = %q, want %q", version, expected)
+			}
+		})
+	}
+}
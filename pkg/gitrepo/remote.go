@@ -0,0 +1,158 @@
+package gitrepo
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// RemoteConfig is an additional remote of the repository, e.g. "upstream"
+// of a fork. Authentication settings have the same meaning as the ones in
+// Config. When none are set go-git defaults are used. Authentication of
+// Config is not used for additional remotes.
+type RemoteConfig struct {
+	// Name is the name of the remote, e.g. "upstream".
+	Name string
+	// URL is the URL of the remote.
+	URL string
+
+	AuthBasicToken            string
+	AuthSSHUser               string
+	AuthSSHPrivateKeyFile     string
+	AuthSSHPrivateKey         []byte
+	AuthSSHPrivateKeyPassword string
+	AuthSSHAgent              bool
+	AuthSSHKnownHostsFiles    []string
+	AuthSSHHostKeys           []string
+}
+
+// repoRemote is a remote fetched by EnsureUpToDate.
+type repoRemote struct {
+	name string
+	url  string
+	auth transport.AuthMethod
+}
+
+// newRemotes validates additional remotes of the config and creates their
+// authentication.
+func newRemotes(c Config, remoteName string) ([]repoRemote, error) {
+	var remotes []repoRemote
+	seen := map[string]bool{remoteName: true}
+	for i, rc := range c.Remotes {
+		if rc.Name == "" || rc.URL == "" {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Remotes[%d] name and URL must not be empty", c, i)}
+		}
+		if plumbing.NewRemoteReferenceName(rc.Name, "master").Validate() != nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Remotes[%d] name %#q must be a valid remote name", c, i, rc.Name)}
+		}
+		if seen[rc.Name] {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Remotes[%d] name %#q is duplicated", c, i, rc.Name)}
+		}
+		seen[rc.Name] = true
+
+		auth, err := newAuth(Config{
+			URL: rc.URL,
+
+			AuthBasicToken:            rc.AuthBasicToken,
+			AuthSSHUser:               rc.AuthSSHUser,
+			AuthSSHPrivateKeyFile:     rc.AuthSSHPrivateKeyFile,
+			AuthSSHPrivateKey:         rc.AuthSSHPrivateKey,
+			AuthSSHPrivateKeyPassword: rc.AuthSSHPrivateKeyPassword,
+			AuthSSHAgent:              rc.AuthSSHAgent,
+			AuthSSHKnownHostsFiles:    rc.AuthSSHKnownHostsFiles,
+			AuthSSHHostKeys:           rc.AuthSSHHostKeys,
+		})
+		if err != nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Remotes[%d] authentication is invalid with error %#q", c, i, err)}
+		}
+
+		remotes = append(remotes, repoRemote{name: rc.Name, url: rc.URL, auth: auth})
+	}
+
+	for i, name := range c.FetchRemotes {
+		if !seen[name] {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.FetchRemotes[%d] %#q is not a configured remote", c, i, name)}
+		}
+	}
+
+	return remotes, nil
+}
+
+// fetchedRemotes returns the remotes fetched by EnsureUpToDate. Additional
+// remotes come first so tags of the primary remote win when tags with the
+// same name point to different objects.
+func (r *Repo) fetchedRemotes() []repoRemote {
+	fetched := func(name string) bool {
+		if len(r.fetchRemotes) == 0 {
+			return true
+		}
+		for _, n := range r.fetchRemotes {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	var remotes []repoRemote
+	for _, rem := range r.remotes {
+		if fetched(rem.name) {
+			remotes = append(remotes, rem)
+		}
+	}
+	if fetched(r.remoteName) {
+		remotes = append(remotes, r.primaryRemote())
+	}
+
+	return remotes
+}
+
+// primaryRemote returns the remote the repository is cloned from.
+func (r *Repo) primaryRemote() repoRemote {
+	return repoRemote{name: r.remoteName, url: r.url, auth: r.auth}
+}
+
+// checkRemotes checks the remotes stored in the existing repository config
+// against the configured ones. Remotes which do not exist yet, e.g. after
+// Config.RemoteName or Config.Remotes changed, are created. See
+// checkRemoteURL for remotes which exist.
+func (r *Repo) checkRemotes(repo *git.Repository) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	for _, rem := range append([]repoRemote{r.primaryRemote()}, r.remotes...) {
+		remoteConfig, ok := cfg.Remotes[rem.name]
+		if !ok {
+			cfg.Remotes[rem.name] = &config.RemoteConfig{
+				Name:  rem.name,
+				URLs:  []string{rem.url},
+				Fetch: []config.RefSpec{config.RefSpec(fmt.Sprintf(config.DefaultFetchRefSpec, rem.name))},
+			}
+			changed = true
+			continue
+		}
+
+		updated, err := r.checkRemoteURL(remoteConfig, rem.url)
+		if err != nil {
+			return err
+		}
+		changed = changed || updated
+	}
+
+	if !changed {
+		return nil
+	}
+
+	err = repo.SetConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package gitrepo
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Test_Repo_remotes tests that additional remotes are fetched and their
+// remote-tracking branches can be resolved.
+func Test_Repo_remotes(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name            string
+		config          Config
+		ref             string
+		expectedContent string
+		expectedVersion string
+		expectedError   error
+	}{
+		{
+			name:            "case 0: upstream branch",
+			ref:             "upstream/main",
+			expectedContent: "u1",
+			expectedVersion: "1.0.0-u1",
+		},
+		{
+			name:            "case 1: branch name resolves to primary remote",
+			ref:             "master",
+			expectedContent: "o0",
+			expectedVersion: "2.0.0",
+		},
+		{
+			name:            "case 2: custom remote name",
+			config:          Config{RemoteName: "fork"},
+			ref:             "fork/master",
+			expectedContent: "o0",
+			expectedVersion: "2.0.0",
+		},
+		{
+			name:          "case 3: upstream not fetched",
+			config:        Config{FetchRemotes: []string{"origin"}},
+			ref:           "upstream/main",
+			expectedError: &ReferenceNotFoundError{},
+		},
+		{
+			name:            "case 4: only upstream fetched",
+			config:          Config{FetchRemotes: []string{"upstream"}},
+			ref:             "upstream/main",
+			expectedContent: "u1",
+			expectedVersion: "1.0.0-u1",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			upstream := newTestOrigin(t)
+			u0 := upstream.commit(nil, map[string]string{"file": "u0"}, "u0", t0)
+			u1 := upstream.commit([]plumbing.Hash{u0}, map[string]string{"file": "u1"}, "u1", t0.Add(time.Hour))
+			upstream.tag("v1.0.0", u0)
+			upstream.branch("main", u1)
+
+			origin := newTestOrigin(t)
+			o0 := origin.commit(nil, map[string]string{"file": "o0"}, "o0", t0)
+			origin.tag("v2.0.0", o0)
+			origin.branch("master", o0)
+
+			c := tc.config
+			c.Remotes = []RemoteConfig{{Name: "upstream", URL: upstream.dir}}
+
+			// FetchRemotes must not prevent the clone from the
+			// primary remote.
+			repo := origin.clone(c)
+
+			content, err := repo.GetFileContent("file", tc.ref)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("err = %v, want %v", err, tc.expectedError)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+			if string(content) != tc.expectedContent {
+				t.Fatalf("content = %q, want %q", content, tc.expectedContent)
+			}
+
+			version, err := repo.ResolveVersion(ctx, tc.ref)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			expectedVersion := tc.expectedVersion
+			if expectedVersion == "1.0.0-u1" {
+				expectedVersion = "1.0.0-" + u1.String()
+			}
+			if version != expectedVersion {
+				t.Fatalf("version = %q, want %q", version, expectedVersion)
+			}
+		})
+	}
+}
+
+// Test_Repo_remotes_prune tests that pruning of a remote does not delete
+// tags fetched from the other remotes.
+func Test_Repo_remotes_prune(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	upstream := newTestOrigin(t)
+	u0 := upstream.commit(nil, nil, "u0", t0)
+	upstream.tag("v1.0.0", u0)
+	upstream.branch("gone", u0)
+	upstream.branch("main", u0)
+
+	origin := newTestOrigin(t)
+	o0 := origin.commit(nil, nil, "o0", t0)
+	origin.tag("v2.0.0", o0)
+	origin.branch("master", o0)
+
+	repo := origin.clone(Config{
+		Prune:   true,
+		Remotes: []RemoteConfig{{Name: "upstream", URL: upstream.dir}},
+	})
+
+	err := upstream.repo.Storer.RemoveReference(plumbing.NewBranchReferenceName("gone"))
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	report, err := repo.EnsureUpToDateWithReport(ctx)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	u, ok := report.Ref("refs/remotes/upstream/gone")
+	if !ok || !u.Deleted {
+		t.Fatalf("update = %#v, %v, want deleted", u, ok)
+	}
+	if len(report.Updates) != 1 {
+		t.Fatalf("len(updates) = %d, want %d", len(report.Updates), 1)
+	}
+
+	for _, name := range []string{"v1.0.0", "v2.0.0"} {
+		_, err := repo.storage.Reference(plumbing.NewTagReferenceName(name))
+		if err != nil {
+			t.Fatalf("tag %q: err = %v, want %v", name, err, nil)
+		}
+	}
+}
+
+func Test_New_remotes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		config        Config
+		expectedError error
+	}{
+		{
+			name:   "case 0: valid remotes",
+			config: Config{Remotes: []RemoteConfig{{Name: "upstream", URL: "https://example.com/upstream.git"}}, FetchRemotes: []string{"origin", "upstream"}},
+		},
+		{
+			name:          "case 1: remote without URL",
+			config:        Config{Remotes: []RemoteConfig{{Name: "upstream"}}},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 2: remote named as the primary remote",
+			config:        Config{Remotes: []RemoteConfig{{Name: "origin", URL: "https://example.com/upstream.git"}}},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 3: unknown fetched remote",
+			config:        Config{FetchRemotes: []string{"upstream"}},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 4: invalid remote name",
+			config:        Config{RemoteName: "in valid"},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 5: invalid remote auth",
+			config:        Config{Remotes: []RemoteConfig{{Name: "upstream", URL: "https://example.com/upstream.git", AuthBasicToken: "token", AuthSSHAgent: true}}},
+			expectedError: &InvalidConfigError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			c := tc.config
+			c.InMemory = true
+			c.URL = "https://example.com/origin.git"
+
+			_, err := New(c)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("err = %v, want %v", err, tc.expectedError)
+			}
+		})
+	}
+}
@@ -4,72 +4,352 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
-var tagRegex = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+`)
-
-var tagPrefixEnvVarName = "GS_GIT_TAG_PREFIX"
-var prefixedTagRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+/v[0-9]+\.[0-9]+\.[0-9]+`)
-
 type Config struct {
 	AuthBasicToken string
 	Dir            string
 	URL            string
+
+	// InMemory stores the repository in memory instead of Dir. It is
+	// meant for short-lived lookups which do not need disk. Dir must be
+	// empty and URL must be set.
+	InMemory bool
+	// Storer and Worktree are custom git storage and worktree filesystem
+	// used instead of Dir. They must be set together and Dir must be
+	// empty.
+	Storer   storage.Storer
+	Worktree billy.Filesystem
+
+	// RemoteName is the name of the remote URL is cloned and fetched
+	// from. Branch names passed to the methods resolve to its
+	// remote-tracking branches. Defaults to "origin".
+	RemoteName string
+	// Remotes are additional remotes fetched by EnsureUpToDate, e.g.
+	// "upstream" of a fork. Their remote-tracking branches can be
+	// referred to as e.g. "upstream/main".
+	Remotes []RemoteConfig
+	// FetchRemotes limits the remotes fetched by EnsureUpToDate to the
+	// given names. Defaults to all the remotes.
+	FetchRemotes []string
+
+	// CloneDepth limits the history cloned and fetched by EnsureUpToDate
+	// to the given number of commits from the tips. When 0 the full
+	// history is fetched. ResolveVersion and NextVersion deepen the
+	// history on demand when the version walk reaches the shallow
+	// boundary.
+	CloneDepth int
+	// SingleBranch limits clone and fetch to the given branch, e.g.
+	// "main", instead of all the branches. Tags are still fetched.
+	SingleBranch string
+	// FetchTagsOnly limits fetches done by EnsureUpToDate after the
+	// initial clone to tags. Branches are not updated.
+	FetchTagsOnly bool
+	// Prune deletes remote-tracking branches and tags which no longer
+	// exist in the remote when fetching.
+	Prune bool
+	// Progress receives progress messages sent by the remote during clones
+	// and fetches, e.g. "Counting objects: 100% (42/42), done.". When nil,
+	// the remote is asked not to send them.
+	Progress io.Writer
+
+	// Lock enables an advisory lock of Dir shared with other processes.
+	// It is taken for clone and fetch so processes sharing
+	// a cache volume do not corrupt each other's repository. The lock
+	// file is Dir with ".lock" suffix. Dir must be set.
+	Lock bool
+	// LockTimeout is the maximum time to wait for the lock held by
+	// another process. Defaults to five minutes.
+	LockTimeout time.Duration
+	// LockStaleAfter is the time after which a lock which is no longer
+	// refreshed by its owner is considered stale and broken. Defaults to
+	// one minute.
+	LockStaleAfter time.Duration
+
+	// RecoverCorrupted makes EnsureUpToDate wipe the repository and clone
+	// it again when the existing repository fails the integrity check,
+	// e.g. after an interrupted clone. Note that Dir is removed including
+	// files not tracked by git. It requires Dir or InMemory to be set.
+	// When not set, RepositoryCorruptedError is returned instead.
+	RecoverCorrupted bool
+	// UpdateRemoteURL makes EnsureUpToDate set the URL of the origin
+	// remote of the existing repository to URL when they do not match.
+	// When not set, RemoteURLMismatchError is returned instead. URLs are
+	// compared regardless of the scheme, scp-like syntax, user, ".git"
+	// suffix and case.
+	UpdateRemoteURL bool
+
+	// RetryMaxAttempts is the maximum number of attempts of each clone and
+	// fetch done by EnsureUpToDate, including the first one. Only errors
+	// matched by RetryIf are retried. Defaults to 1, i.e. no retries.
+	RetryMaxAttempts int
+	// RetryBackoff is the time to wait before the first retry. It is
+	// doubled after each retry. Defaults to one second.
+	RetryBackoff time.Duration
+	// RetryMaxBackoff is the maximum time to wait between attempts.
+	// Defaults to 30 seconds.
+	RetryMaxBackoff time.Duration
+	// RetryJitter is the fraction of the backoff by which it is randomly
+	// shortened or extended. Defaults to 0.1. Set it to a negative value
+	// to disable jitter.
+	RetryJitter float64
+	// RetryIf returns true for errors which should be retried. Defaults
+	// to IsTransient.
+	RetryIf func(error) bool
+
+	// AuthSSHUser is the user used for SSH authentication. Defaults to
+	// the user of the SSH URL, e.g. "deploy" in
+	// "ssh://deploy@example.com/repo.git", or "git". It must match the
+	// user of the URL when both are set.
+	AuthSSHUser string
+	// AuthSSHPrivateKeyFile is a path to PEM encoded private key used for
+	// SSH authentication.
+	AuthSSHPrivateKeyFile string
+	// AuthSSHPrivateKey is PEM encoded private key used for SSH
+	// authentication. It is mutually exclusive with
+	// AuthSSHPrivateKeyFile.
+	AuthSSHPrivateKey []byte
+	// AuthSSHPrivateKeyPassword is the passphrase of an encrypted private
+	// key set in AuthSSHPrivateKeyFile or AuthSSHPrivateKey.
+	AuthSSHPrivateKeyPassword string
+	// AuthSSHAgent enables SSH authentication with keys provided by the
+	// ssh-agent listening on SSH_AUTH_SOCK.
+	AuthSSHAgent bool
+	// AuthSSHKnownHostsFiles are known_hosts files used to verify the SSH
+	// server host key. When neither AuthSSHKnownHostsFiles nor
+	// AuthSSHHostKeys are set, files from SSH_KNOWN_HOSTS environment
+	// variable or ~/.ssh/known_hosts are used.
+	AuthSSHKnownHostsFiles []string
+	// AuthSSHHostKeys are pinned SSH server host keys in authorized_keys
+	// format, e.g. "ssh-ed25519 AAAAC3Nza...". The server must present
+	// one of them. It is mutually exclusive with AuthSSHKnownHostsFiles.
+	AuthSSHHostKeys []string
+
+	// VersionFormat defines how ResolveVersion formats resolved versions.
+	// Defaults to VersionFormatDefault.
+	VersionFormat VersionFormat
+	// VersionPaths enables path-scoped versioning for modules stored in
+	// subdirectories of a monorepo. When set, ResolveVersion resolves the
+	// version of the most recent commit touching any of the paths instead
+	// of the commit the reference points to. Version tags on later commits
+	// not touching the paths, e.g. release or merge commits, are still
+	// returned. Paths are relative to the repository root.
+	VersionPaths []string
+	// VersionStrategy defines how ResolveVersion selects the version of
+	// untagged references when multiple tagged parents are reachable.
+	// Defaults to VersionStrategyMostRecent.
+	VersionStrategy VersionStrategy
+	// PreReleaseChannel is the pre-release channel of versions computed by
+	// NextVersion, e.g. "rc" for versions like "1.3.0-rc.1". When empty,
+	// NextVersion computes release versions.
+	PreReleaseChannel string
+
+	// TagPrefix is the tag prefix of a monorepo module. When set, only tags
+	// in format "<TagPrefix>/<tag>" are considered by HeadTag and
+	// ResolveVersion, e.g. "module-a/v1.2.3". When empty, the value of
+	// GS_GIT_TAG_PREFIX environment variable is used.
+	TagPrefix string
+	// VersionTagPattern is a regular expression matching version tags
+	// (with TagPrefix trimmed). It must have a group named "version"
+	// capturing the version, e.g. `^release-(?P<version>\d+\.\d+\.\d+)$`
+	// for tags like "release-1.2.3". Defaults to tags in format "vX.Y.Z".
+	VersionTagPattern string
+
+	// RefResolution defines how branch names passed to the methods are
+	// resolved. Defaults to RefResolutionRemoteFirst.
+	RefResolution RefResolution
+
+	// Logger receives debug logs of the operations, e.g. the tags
+	// considered by ResolveVersion and the commit which ended the version
+	// walk, and warnings about retries and recoveries. Records have the
+	// "url" attribute with the password redacted. Defaults to discarding
+	// the logs.
+	Logger *slog.Logger
+	// Tracer starts spans around clones, fetches, tag enumeration and
+	// the version walk. See Span* constants for the span
+	// names. Defaults to no tracing.
+	Tracer Tracer
+	// Metrics records durations and errors of the operations and data
+	// fetched by EnsureUpToDate. Create it with NewMetrics. It may be
+	// shared by multiple Repos. Defaults to no metrics.
+	Metrics *Metrics
 }
 
+// Repo is safe for concurrent use by multiple goroutines. Operations are
+// serialized, so e.g. GetFileContent never reads objects while
+// EnsureUpToDate fetches. go-git storages are not safe for concurrent
+// reads either, so reads are serialized too.
+//
+// As a consequence an operation waits for the one in progress to finish.
+// EnsureUpToDate holds the Repo for the whole clone or fetch including the
+// backoff between retries and the wait for Config.Lock, and ResolveVersion
+// and NextVersion hold it while deepening shallow clones. That can take
+// minutes with slow remotes. Methods taking a context stop waiting between
+// retries and for the lock when it is canceled, but GetFileContent and
+// GetFolderContent do not take one and wait until the Repo is free. Use
+// separate Repos with separate directories when reads must not wait for
+// updates.
 type Repo struct {
-	url string
+	url           string
+	versionFormat VersionFormat
+	versionPaths  []string
+
+	versionStrategy   VersionStrategy
+	preReleaseChannel string
+
+	tagPrefix         string
+	versionTagPattern *regexp.Regexp
+
+	refResolution RefResolution
+
+	cloneDepth    int
+	singleBranch  string
+	fetchTagsOnly bool
+	prune         bool
+	progress      io.Writer
+
+	remoteName   string
+	remotes      []repoRemote
+	fetchRemotes []string
+
+	// lock is nil when Config.Lock is not set.
+	lock *fileLock
+
+	// dir is empty when the repository is not stored in Config.Dir.
+	dir              string
+	inMemory         bool
+	recoverCorrupted bool
+	updateRemoteURL  bool
+
+	retry retryPolicy
+
+	logger  *slog.Logger
+	tracer  Tracer
+	metrics *Metrics
 
 	auth     transport.AuthMethod
-	storage  *filesystem.Storage
+	storage  storage.Storer
 	worktree billy.Filesystem
+
+	// mu serializes operations on the repository. It guards repo, storage
+	// and worktree, none of which are safe for concurrent use.
+	mu   sync.Mutex
+	repo *git.Repository
 }
 
 func New(config Config) (*Repo, error) {
-	if config.Dir == "" {
+	if (config.Storer == nil) != (config.Worktree == nil) {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Storer and %T.Worktree must be set together", config, config)}
+	}
+	if config.Dir != "" && config.InMemory {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Dir and %T.InMemory must not be set together", config, config)}
+	}
+	if config.Dir != "" && config.Storer != nil {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Dir and %T.Storer must not be set together", config, config)}
+	}
+	if config.InMemory && config.Storer != nil {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.InMemory and %T.Storer must not be set together", config, config)}
+	}
+	if config.Dir == "" && !config.InMemory && config.Storer == nil {
 		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Dir must not be empty", config)}
 	}
+	if err := config.VersionFormat.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.VersionStrategy.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.RefResolution.validate(); err != nil {
+		return nil, err
+	}
+	if config.PreReleaseChannel != "" && !preReleaseChannelRegex.MatchString(config.PreReleaseChannel) {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.PreReleaseChannel %#q must be an alphanumeric identifier", config, config.PreReleaseChannel)}
+	}
+	versionPaths, err := validateVersionPaths(config.VersionPaths)
+	if err != nil {
+		return nil, err
+	}
+	versionTagPattern, err := compileVersionTagPattern(config.VersionTagPattern)
+	if err != nil {
+		return nil, err
+	}
+	if config.CloneDepth < 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.CloneDepth must not be negative", config)}
+	}
+	if config.SingleBranch != "" && plumbing.NewBranchReferenceName(config.SingleBranch).Validate() != nil {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.SingleBranch %#q must be a valid branch name", config, config.SingleBranch)}
+	}
 
-	var auth transport.AuthMethod
-	{
-		if config.AuthBasicToken != "" {
-			auth = &http.BasicAuth{
-				Username: "can-be-anything-but-not-empty",
-				Password: config.AuthBasicToken,
-			}
-		}
+	if config.Lock && config.Dir == "" {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Lock requires %T.Dir to be set", config, config)}
+	}
+	if config.RecoverCorrupted && config.Storer != nil {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.RecoverCorrupted must not be set together with %T.Storer", config, config)}
+	}
+	if config.LockTimeout < 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.LockTimeout must not be negative", config)}
+	}
+	if config.LockStaleAfter < 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.LockStaleAfter must not be negative", config)}
+	}
+
+	retry, err := newRetryPolicy(config)
+	if err != nil {
+		return nil, err
 	}
 
-	worktree := osfs.New(config.Dir)
-	fs := osfs.New(filepath.Join(config.Dir, ".git"))
-	storage := filesystem.NewStorageWithOptions(fs, cache.NewObjectLRUDefault(), filesystem.Options{})
+	remoteName := config.RemoteName
+	if remoteName == "" {
+		remoteName = git.DefaultRemoteName
+	}
+	if plumbing.NewRemoteReferenceName(remoteName, "master").Validate() != nil {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.RemoteName %#q must be a valid remote name", config, config.RemoteName)}
+	}
+	remotes, err := newRemotes(config, remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	var storer storage.Storer
+	var worktree billy.Filesystem
+	switch {
+	case config.InMemory:
+		storer = memory.NewStorage()
+		worktree = memfs.New()
+	case config.Storer != nil:
+		storer = config.Storer
+		worktree = config.Worktree
+	default:
+		storer, worktree = newDirStorage(config.Dir)
+	}
 
 	// When URL is not configured assume the repository is cloned on disk
-	// and take the URL or origin remote.
+	// and take the URL of the remote.
 	if config.URL == "" {
-		repo, err := git.Open(storage, worktree)
+		repo, err := git.Open(storer, worktree)
 		if err != nil {
 			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.URL not set and failed to open repository with error %#q", config, err)}
 		}
 
-		remoteName := "origin"
-
 		remote, err := repo.Remote(remoteName)
 		if err != nil {
 			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.URL not set and failed to find remote with name %#q with error %#q", config, remoteName, err)}
@@ -85,11 +365,63 @@ func New(config Config) (*Repo, error) {
 		config.URL = remote.Config().URLs[0]
 	}
 
+	auth, err := newAuth(config)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	tracer := config.Tracer
+	if tracer == nil {
+		tracer = noopTracer{}
+	}
+
+	var lock *fileLock
+	if config.Lock {
+		lock = newFileLock(config.Dir, config.LockTimeout, config.LockStaleAfter)
+	}
+
 	r := &Repo{
-		url: config.URL,
+		url:           config.URL,
+		versionFormat: config.VersionFormat,
+		versionPaths:  versionPaths,
+
+		versionStrategy:   config.VersionStrategy,
+		preReleaseChannel: config.PreReleaseChannel,
+
+		tagPrefix:         config.TagPrefix,
+		versionTagPattern: versionTagPattern,
+
+		refResolution: config.RefResolution,
+
+		cloneDepth:    config.CloneDepth,
+		singleBranch:  config.SingleBranch,
+		fetchTagsOnly: config.FetchTagsOnly,
+		prune:         config.Prune,
+		progress:      config.Progress,
+
+		remoteName:   remoteName,
+		remotes:      remotes,
+		fetchRemotes: config.FetchRemotes,
+
+		lock: lock,
+
+		dir:              config.Dir,
+		inMemory:         config.InMemory,
+		recoverCorrupted: config.RecoverCorrupted,
+		updateRemoteURL:  config.UpdateRemoteURL,
+
+		retry: retry,
+
+		logger:  logger.With(slog.String("url", redact(config.URL, urlSecret(config.URL)))),
+		tracer:  tracer,
+		metrics: config.Metrics,
 
 		auth:     auth,
-		storage:  storage,
+		storage:  storer,
 		worktree: worktree,
 	}
 
@@ -97,11 +429,168 @@ func New(config Config) (*Repo, error) {
 }
 
 // EnsureUpToDate fetches latest changes from remote.
+//
+// The worktree is checked out on the initial clone only when its root does not
+// exist yet. In particular in-memory repositories are cloned without checkout.
+// All the read methods work on git objects so they do not need it.
+//
+// Clone and fetch are aborted when the context is canceled or its deadline
+// is exceeded. In that case the returned error matches CanceledError.
 func (r *Repo) EnsureUpToDate(ctx context.Context) error {
+	_, err := r.EnsureUpToDateWithReport(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EnsureUpToDateWithReport is EnsureUpToDate which also reports references
+// changed by the clone or fetch and the data transferred.
+//
+// Tags are fetched with an explicit "+refs/tags/*:refs/tags/*" refspec so tags
+// moved in the remote are updated locally. When Config.Prune is set,
+// remote-tracking branches and tags deleted in the remote are deleted locally
+// as well. Note that this includes local tags which are not pushed yet.
+func (r *Repo) EnsureUpToDateWithReport(ctx context.Context) (*UpdateReport, error) {
+	start := time.Now()
+
+	report, err := r.doEnsureUpToDate(ctx)
+	r.metrics.observe(r.redactedURL(), operationEnsureUpToDate, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// The size is measured with the repository unlocked so walking the
+	// directory does not block other operations.
+	err = r.metrics.observeSize(r.redactedURL(), r.dir)
+	if err != nil {
+		r.logger.WarnContext(ctx, "failed to measure repository size", slog.Any("error", err))
+	}
+
+	return report, nil
+}
+
+func (r *Repo) doEnsureUpToDate(ctx context.Context) (*UpdateReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unlock, err := r.acquireLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	before, err := refSnapshot(r.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var transfer TransferStats
+	start := time.Now()
+
+	repo, cloned, err := r.cloneOrOpen(ctx, &transfer)
+	var recoveredFrom error
+	if errors.Is(err, &RepositoryCorruptedError{}) && r.recoverCorrupted {
+		recoveredFrom = err
+		r.logger.WarnContext(ctx, "cloning corrupted repository again", slog.Any("error", err))
+
+		err = r.reset(false)
+		if err != nil {
+			return nil, err
+		}
+
+		before = map[plumbing.ReferenceName]plumbing.Hash{}
+		repo, cloned, err = r.cloneOrOpen(ctx, &transfer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch through a repository with the storage wrapped so received
+	// packfiles are counted.
+	fetchRepo, err := git.Open(newTransferStorer(r.storage, &transfer), r.worktree)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, remote := range r.fetchedRemotes() {
+		fetchOpts, err := r.fetchOptions(repo, remote)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, opts := range fetchOpts {
+			err = r.retry.do(ctx, r.logger, func() error {
+				return r.fetch(ctx, fetchRepo, remote, opts)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	after, err := refSnapshot(r.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := newUpdateReport(ctx, repo, before, after)
+	if err != nil {
+		return nil, err
+	}
+	report.Cloned = cloned
+	report.Recovered = recoveredFrom != nil
+	report.RecoveredFrom = recoveredFrom
+	report.Transfer = transfer
+	report.Transfer.Fetched = transfer.Objects > 0
+	report.Transfer.Duration = time.Since(start)
+
+	r.logger.DebugContext(ctx, "updated repository",
+		slog.Bool("cloned", report.Cloned),
+		slog.Int("updates", len(report.Updates)),
+		slog.Int("objects", report.Transfer.Objects),
+		slog.Int64("bytes", report.Transfer.Bytes),
+		slog.Duration("duration", report.Transfer.Duration),
+	)
+
+	r.metrics.observeUpdate(r.redactedURL(), report)
+
+	return report, nil
+}
+
+// cloneOrOpen is tryCloneOrOpen retried according to the retry policy.
+func (r *Repo) cloneOrOpen(ctx context.Context, transfer *TransferStats) (*git.Repository, bool, error) {
+	var repo *git.Repository
+	var cloned bool
+	err := r.retry.do(ctx, r.logger, func() error {
+		var err error
+		repo, cloned, err = r.tryCloneOrOpen(ctx, transfer)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return repo, cloned, nil
+}
+
+// tryCloneOrOpen clones the repository unless it exists already, in which
+// case the existing repository is opened and checked. It returns whether the
+// repository was cloned. A failed clone is removed so the next attempt clones
+// again. Received packfiles are counted in transfer.
+func (r *Repo) tryCloneOrOpen(ctx context.Context, transfer *TransferStats) (*git.Repository, bool, error) {
 	cloneOpts := &git.CloneOptions{
 		Auth:       r.auth,
 		URL:        r.url,
 		NoCheckout: true,
+		Depth:      r.cloneDepth,
+		RemoteName: r.remoteName,
+		Progress:   r.progress,
+	}
+	if r.singleBranch != "" {
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(r.singleBranch)
+		cloneOpts.SingleBranch = true
 	}
 
 	_, err := r.worktree.Stat("/")
@@ -109,45 +598,102 @@ func (r *Repo) EnsureUpToDate(ctx context.Context) error {
 		// Repo is empty so perform an initial checkout
 		cloneOpts.NoCheckout = false
 	} else if err != nil {
-		return err
+		return nil, false, err
+	}
+
+	// Only remove what the failed clone created. The worktree of
+	// in-memory repositories always exists. Custom storage is left to
+	// the caller.
+	cleanup := r.inMemory
+	if r.dir != "" {
+		_, err := os.Stat(filepath.Join(r.dir, ".git"))
+		cleanup = os.IsNotExist(err)
+	}
+
+	repo, err := r.clone(ctx, cloneOpts, transfer)
+	if err == nil {
+		// Do not cache the repository with the wrapped storage.
+		r.repo = nil
+		repo, err = r.open()
+		if err != nil {
+			return nil, false, err
+		}
+
+		err = r.checkRemotes(repo)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return repo, true, nil
 	}
 
-	repo, err := git.Clone(r.storage, r.worktree, cloneOpts)
 	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
-		repo, err = git.Open(r.storage, r.worktree)
+		repo, err = r.open()
 		if err != nil {
-			return err
+			return nil, false, &RepositoryCorruptedError{message: fmt.Sprintf("failed to open repository of %#q with error %#q", r.redactedURL(), err), cause: err}
 		}
-	} else if errors.Is(err, transport.ErrRepositoryNotFound) {
-		return &RepositoryNotFoundError{message: fmt.Sprintf("%#q", r.url)}
-	} else if err != nil {
-		return err
+
+		err = r.checkIntegrity(ctx, repo)
+		if err != nil {
+			return nil, false, err
+		}
+
+		err = r.checkRemotes(repo)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return repo, false, nil
+	} else if errors.Is(err, git.ErrRemoteExists) {
+		// The repository config exists without HEAD.
+		return nil, false, &RepositoryCorruptedError{message: fmt.Sprintf("failed to clone %#q into existing repository with error %#q", r.redactedURL(), err), cause: err}
 	}
 
-	fetchOpts := &git.FetchOptions{
-		Auth:  r.auth,
-		Force: true,
+	if cleanup {
+		rerr := r.reset(cloneOpts.NoCheckout)
+		if rerr != nil {
+			return nil, false, rerr
+		}
 	}
 
-	err = repo.Fetch(fetchOpts)
-	if errors.Is(err, git.NoErrAlreadyUpToDate) {
-		// Fall through.
-	} else if errors.Is(err, transport.ErrRepositoryNotFound) {
-		// This could happen if the repository does not exist, but you already have the folder on the filesystem.
-		// In that case Fetch will be the first to realise that repo does not exist since Clone only performs an Open.
-		// Also, Clone creates the folder on the filesystem even if it fails, so you end simulate the same situation when
-		// you call EnsureUpToDate more that once on the same non-existent repo.
-		return &RepositoryNotFoundError{message: fmt.Sprintf("%#q", r.url)}
-	} else if err != nil {
-		return err
+	return nil, false, transportError(r.primaryRemote(), err)
+}
+
+// clone clones the repository. It returns git.ErrRepositoryAlreadyExists when
+// the repository exists already.
+func (r *Repo) clone(ctx context.Context, opts *git.CloneOptions, transfer *TransferStats) (*git.Repository, error) {
+	ctx, span := r.startSpan(ctx, SpanClone, slog.String("remote", opts.RemoteName), slog.Int("depth", opts.Depth))
+
+	repo, err := git.CloneContext(ctx, newTransferStorer(r.storage, transfer), r.worktree, opts)
+	err = contextError(ctx, "clone", err)
+	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		span.SetAttributes(slog.Bool("exists", true))
+		span.End(nil)
+		return nil, err
 	}
 
-	return nil
+	span.SetAttributes(slog.Bool("exists", false))
+	span.End(transportError(r.primaryRemote(), err))
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.DebugContext(ctx, "cloned repository", slog.String("remote", opts.RemoteName), slog.Int("depth", opts.Depth))
+
+	return repo, nil
 }
 
 // HeadBranch returns branch name for the HEAD ref.
 func (r *Repo) HeadBranch(ctx context.Context) (string, error) {
-	repo, err := git.Open(r.storage, r.worktree)
+	err := checkContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, err := r.open()
 	if err != nil {
 		return "", err
 	}
@@ -162,7 +708,15 @@ func (r *Repo) HeadBranch(ctx context.Context) (string, error) {
 
 // HeadSHA returns sha for the HEAD ref.
 func (r *Repo) HeadSHA(ctx context.Context) (string, error) {
-	repo, err := git.Open(r.storage, r.worktree)
+	err := checkContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, err := r.open()
 	if err != nil {
 		return "", err
 	}
@@ -177,16 +731,24 @@ func (r *Repo) HeadSHA(ctx context.Context) (string, error) {
 
 // HeadTag returns tag for the HEAD ref.
 //
-// If GS_TAG_PREFIX environment variable is set, it looks for tags prefixed with that.
-// For example, when the value is 'module-a', it filters found tags to 'module-a/v1.2.0',
-// must match <module_name>/v<semantic_version>.
+// If Config.TagPrefix, or GS_GIT_TAG_PREFIX environment variable as a fallback, is set, it looks for tags
+// prefixed with that. For example, when the value is 'module-a', it filters found tags to 'module-a/v1.2.0',
+// must match <module_name>/<version_tag>.
 //
-// Note: if GS_TAG_PREFIX is not set, all tags matching the prefixed tag regex are filtered out!
+// Note: if the tag prefix is not set, all tags of other modules, i.e. <module_name>/<version_tag>, are filtered out!
 //
 // It returns error handled by IsReferenceNotFound if the HEAD ref is not
 // tagged.
 func (r *Repo) HeadTag(ctx context.Context) (string, error) {
-	repo, err := git.Open(r.storage, r.worktree)
+	err := checkContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, err := r.open()
 	if err != nil {
 		return "", err
 	}
@@ -196,35 +758,27 @@ func (r *Repo) HeadTag(ctx context.Context) (string, error) {
 		return "", err
 	}
 
-	tagsBySHA, err := r.tags(repo)
+	tagsBySHA, err := r.tags(ctx, repo)
 	if err != nil {
 		return "", err
 	}
 
 	tags := tagsBySHA[head.Hash().String()]
 
-	tagPrefix := os.Getenv(tagPrefixEnvVarName)
+	matcher := r.tagMatcher()
 
 	var filteredTags []string
-	if tagPrefix != "" {
-		for _, tag := range tags {
-			if strings.HasPrefix(tag, tagPrefix+"/") {
-				filteredTags = append(filteredTags, tag)
-			}
-		}
-	} else {
-		for _, tag := range tags {
-			if !prefixedTagRegex.MatchString(tag) {
-				filteredTags = append(filteredTags, tag)
-			}
+	for _, tag := range tags {
+		if matcher.owns(tag) {
+			filteredTags = append(filteredTags, tag)
 		}
 	}
 
 	if len(filteredTags) == 0 {
-		return "", &ReferenceNotFoundError{message: fmt.Sprintf("HEAD ref is not tagged (filtered for prefix: '%s')", tagPrefix)}
+		return "", &ReferenceNotFoundError{Ref: plumbing.HEAD.String(), message: fmt.Sprintf("HEAD ref is not tagged (filtered for prefix: '%s')", matcher.prefix)}
 	}
 	if len(filteredTags) > 1 {
-		return "", &ExecutionFailedError{message: fmt.Sprintf("HEAD ref has multiple tags %v (filtered for prefix: '%s')", filteredTags, tagPrefix)}
+		return "", &ExecutionFailedError{message: fmt.Sprintf("HEAD ref has multiple tags %v (filtered for prefix: '%s')", filteredTags, matcher.prefix)}
 	}
 
 	return filteredTags[0], nil
@@ -237,150 +791,122 @@ func (r *Repo) HeadTag(ctx context.Context) (string, error) {
 // recent parent commit tagged with "vX.Y.Z" or "0.0.0" if no such parent exist
 // and "SHA" part is the git SHA of the given reference.
 //
-// If GS_TAG_PREFIX environment variable is set, it looks for tag with prefixed with '<env_var_value>/'.
-// The second half of the tag must still be semantic versioned, e.g. 'module-a/v1.2.3'. The prefix, the separator
-// and the v prefix is removed from the returned result, similar to the default behaviour, e.g. for the example
-// it will return '1.2.3'. Git hash postfix for references after the last found tag works here just the same.q
+// When Config.VersionStrategy is VersionStrategyHighest the "X.Y.Z" part is
+// the highest version, by semantic version precedence, of all tagged parents
+// reachable without crossing another tagged commit instead.
+//
+// If Config.TagPrefix, or GS_GIT_TAG_PREFIX environment variable as a fallback, is set, it looks for tag with
+// prefixed with '<prefix>/'. The second half of the tag must still be a version tag, e.g. 'module-a/v1.2.3'. The
+// prefix, the separator and the v prefix is removed from the returned result, similar to the default behaviour,
+// e.g. for the example it will return '1.2.3'. Git hash postfix for references after the last found tag works
+// here just the same.
+//
+// Version tags are matched with Config.VersionTagPattern, when set, instead of "vX.Y.Z". The returned version is
+// the value of its "version" group, e.g. '1.2.3' for 'release-1.2.3' with pattern
+// `^release-(?P<version>\d+\.\d+\.\d+)$`.
+//
+// When Config.VersionFormat is VersionFormatGo the versions are Go module
+// versions instead, i.e. "vX.Y.Z" for tagged references and pseudo-versions
+// like "vX.Y.(Z+1)-0.yyyymmddhhmmss-abcdefabcdef" for untagged ones. See
+// VersionFormatGo for details.
+//
+// When Config.VersionPaths is set, the version is resolved for the most
+// recent commit reachable from the reference which touches any of the paths.
+// In particular, the reference is considered tagged only when that commit is
+// tagged and the "SHA" part of pseudo-versions is the SHA of that commit. This
+// way the version of a module in a monorepo only moves when its own files
+// change. It is meant to be used together with GS_GIT_TAG_PREFIX.
 //
 // It returns error handled by IsReferenceNotFound if the HEAD ref is not
 // tagged.
+//
+// The history walk is aborted with CanceledError when the context is
+// canceled or its deadline is exceeded.
+//
+// In shallow clones, see Config.CloneDepth, the history is deepened on demand
+// when the walk reaches the shallow boundary. It returns error matching
+// ShallowHistoryError if the history cannot be deepened any further.
 func (r *Repo) ResolveVersion(ctx context.Context, ref string) (string, error) {
-	repo, err := git.Open(r.storage, r.worktree)
+	start := time.Now()
+
+	version, err := r.doResolveVersion(ctx, ref)
+	r.metrics.observe(r.redactedURL(), operationResolveVersion, start, err)
 	if err != nil {
 		return "", err
 	}
 
-	tagPrefix := os.Getenv(tagPrefixEnvVarName)
-
-	versionsByHash := map[string]string{}
-	{
-
-		tagsByHash, err := r.tags(repo)
-		if err != nil {
-			return "", err
-		}
-		for hash, tags := range tagsByHash {
-			for _, t := range tags {
-				var versionTags []string
-
-				if tagPrefix != "" {
-					if prefixedTagRegex.MatchString(t) && strings.HasPrefix(t, tagPrefix+"/") {
-						versionTags = append(versionTags, t)
-						versionsByHash[hash] = strings.TrimPrefix(strings.TrimPrefix(t, tagPrefix+"/"), "v")
-					}
-				} else {
-					if tagRegex.MatchString(t) {
-						versionTags = append(versionTags, t)
-						versionsByHash[hash] = strings.TrimPrefix(t, "v")
-					}
-				}
-
-				if len(versionTags) > 1 {
-					return "", &ExecutionFailedError{message: fmt.Sprintf("multiple version tags %#v found for hash %#q", versionTags, hash)}
-				}
-			}
-		}
+	return version, nil
+}
 
+func (r *Repo) doResolveVersion(ctx context.Context, ref string) (string, error) {
+	err := checkContext(ctx)
+	if err != nil {
+		return "", err
 	}
 
-	var commit *object.Commit
-	{
-		hash, err := repo.ResolveRevision(plumbing.Revision(ref))
-		if errors.Is(err, plumbing.ErrReferenceNotFound) {
-			return "", &ReferenceNotFoundError{message: fmt.Sprintf("%#q", ref)}
-		} else if err != nil {
-			return "", err
-		}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-		commit, err = repo.CommitObject(*hash)
-		if err != nil {
-			return "", err
-		}
+	repo, err := r.open()
+	if err != nil {
+		return "", err
 	}
 
-	// When the commit is tagged return the tag.
-	{
-		version, ok := versionsByHash[commit.Hash.String()]
-		if ok {
-			return version, nil
-		}
+	var version string
+	err = r.withDeepening(ctx, repo, func() error {
+		var err error
+		version, err = r.resolveVersion(ctx, repo, ref)
+		return err
+	})
+	if err != nil {
+		return "", err
 	}
 
-	// Otherwise find the first tagged parent and return it's tag glued
-	// with the SHA.
-	var pseudoVersion string
-	{
-		var lastVersion string
-
-		queue := []*object.Commit{
-			commit,
-		}
-
-		for {
-			if len(queue) == 0 {
-				lastVersion = "0.0.0"
-				break
-			}
+	return version, nil
+}
 
-			// Pop the first element from the queue.
-			c := queue[0]
-			queue = queue[1:]
+func (r *Repo) resolveVersion(ctx context.Context, repo *git.Repository, ref string) (string, error) {
+	versionsByHash, err := r.versionsByHash(ctx, repo)
+	if err != nil {
+		return "", err
+	}
 
-			// Check if this commit is tagged. If so the most
-			// recent tag is found and loop should be finished.
-			v, ok := versionsByHash[c.Hash.String()]
-			if ok {
-				lastVersion = v
-				break
-			}
+	commit, err := r.resolveCommit(repo, ref)
+	if err != nil {
+		return "", err
+	}
 
-			// Push all the parents to the queue.
-			err = c.Parents().ForEach(func(p *object.Commit) error {
-				// If the commit is already in the queue skip
-				// it. This is possible multiple commits have
-				// the same parent. Adding all of them to the
-				// queue may lead in exponential growth of the
-				// queue resulting in extremely long execution.
-				for _, c := range queue {
-					if c.Hash == p.Hash {
-						return nil
-					}
-				}
-
-				queue = append(queue, p)
-				return nil
-			})
-			if err != nil {
-				return "", err
-			}
+	// With path-scoped versioning the version only changes when the
+	// paths change so the version is resolved for the most recent commit
+	// touching them.
+	commit, lastVersion, _, err := r.versionBase(ctx, repo, commit, versionsByHash)
+	if err != nil {
+		return "", err
+	}
 
-			// Sort commits in the queue by commit date in
-			// descending order to find the most recent tag first.
-			sort.Slice(queue, func(i, j int) bool { return queue[i].Committer.When.After(queue[j].Committer.When) })
+	// When the commit is tagged return the tag.
+	{
+		version, ok := versionsByHash[commit.Hash.String()]
+		if ok {
+			return formatVersion(r.versionFormat, version)
 		}
-
-		pseudoVersion = lastVersion + "-" + commit.Hash.String()
 	}
 
-	return pseudoVersion, nil
+	// Otherwise return the tag of the tagged parent glued with the SHA.
+	return formatPseudoVersion(r.versionFormat, lastVersion, commit)
 }
 
 // GetFileContent retrieves content of file stored at path on version specified in ref.
 // When empty ref defaults to master branch.
+//
+// The content is read from git objects of the commit the ref points to. The
+// worktree and HEAD are left untouched. It waits for the operation in
+// progress, e.g. EnsureUpToDate, to finish. See Repo.
 func (r *Repo) GetFileContent(path, ref string) ([]byte, error) {
-	worktree, err := r.checkoutRef(ref)
-	if err != nil {
-		return nil, err
-	}
+	start := time.Now()
 
-	file, err := worktree.Filesystem.Open(path)
-	if os.IsNotExist(err) {
-		return nil, &FileNotFoundError{message: fmt.Sprintf("%#q", path)}
-	} else if err != nil {
-		return nil, err
-	}
-
-	content, err := io.ReadAll(file)
+	content, err := r.doGetFileContent(path, ref)
+	r.metrics.observe(r.redactedURL(), operationGetFileContent, start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -388,72 +914,67 @@ func (r *Repo) GetFileContent(path, ref string) ([]byte, error) {
 	return content, nil
 }
 
-// GetFolderContent retrieves content of a folder stored at path on version specified in ref.
-// When empty ref defaults to master branch.
-func (r *Repo) GetFolderContent(path, ref string) ([]os.FileInfo, error) {
-	worktree, err := r.checkoutRef(ref)
+func (r *Repo) doGetFileContent(path, ref string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, err := r.open()
 	if err != nil {
 		return nil, err
 	}
 
-	files, err := worktree.Filesystem.ReadDir(path)
-	if os.IsNotExist(err) {
-		return nil, &FolderNotFoundError{message: fmt.Sprintf("%#q", path)}
-	} else if err != nil {
+	_, tree, err := r.resolveTree(repo, ref)
+	if err != nil {
 		return nil, err
 	}
 
-	return files, nil
+	return readTreeFile(tree, path)
 }
 
-func (r *Repo) checkoutRef(ref string) (*git.Worktree, error) {
-	repo, err := git.Open(r.storage, r.worktree)
+// GetFolderContent retrieves content of a folder stored at path on version specified in ref.
+// When empty ref defaults to master branch.
+//
+// The content is read from git objects of the commit the ref points to. The
+// worktree and HEAD are left untouched. It waits for the operation in
+// progress, e.g. EnsureUpToDate, to finish. See Repo.
+func (r *Repo) GetFolderContent(path, ref string) ([]os.FileInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, err := r.open()
 	if err != nil {
 		return nil, err
 	}
 
-	worktree, err := repo.Worktree()
+	commit, tree, err := r.resolveTree(repo, ref)
 	if err != nil {
 		return nil, err
 	}
 
-	// When empty CheckoutOptions defaults to master branch.
-	opt := &git.CheckoutOptions{}
-	if ref != "" {
-		hash, err := repo.ResolveRevision(plumbing.Revision(ref))
-		if errors.Is(err, plumbing.ErrReferenceNotFound) {
-			return nil, &ReferenceNotFoundError{message: fmt.Sprintf("%#q", ref)}
-		} else if err != nil {
-			return nil, err
-		}
-
-		head, err := repo.Head()
-		if err != nil {
-			return nil, err
-		}
-
-		if head.Hash() == *hash {
-			// We're already at the right ref, no need to checkout
-			return worktree, nil
-		}
+	return readTreeDir(commit, tree, path)
+}
 
-		opt.Hash = *hash
-	}
+// tags returns names of the tags by SHA of the tagged commit.
+func (r *Repo) tags(ctx context.Context, repo *git.Repository) (map[string][]string, error) {
+	ctx, span := r.startSpan(ctx, SpanListTags)
 
-	err = worktree.Checkout(opt)
+	tags, err := listTags(ctx, repo)
 	if err != nil {
+		span.End(err)
 		return nil, err
 	}
 
-	err = worktree.Clean(&git.CleanOptions{Dir: true})
-	if err != nil {
-		return nil, err
+	var n int
+	for _, v := range tags {
+		n += len(v)
 	}
+	span.SetAttributes(slog.Int("tags", n))
+	span.End(nil)
 
-	return worktree, nil
+	return tags, nil
 }
 
-func (r *Repo) tags(repo *git.Repository) (map[string][]string, error) {
+func listTags(ctx context.Context, repo *git.Repository) (map[string][]string, error) {
 	tags := map[string][]string{}
 
 	// Get lightweight tags.
@@ -465,6 +986,11 @@ func (r *Repo) tags(repo *git.Repository) (map[string][]string, error) {
 		defer tagsIter.Close()
 
 		err = tagsIter.ForEach(func(tag *plumbing.Reference) error {
+			err := checkContext(ctx)
+			if err != nil {
+				return err
+			}
+
 			v := tags[tag.Hash().String()]
 			if v == nil {
 				v = []string{}
@@ -489,6 +1015,11 @@ func (r *Repo) tags(repo *git.Repository) (map[string][]string, error) {
 		defer tagObjectsIter.Close()
 
 		err = tagObjectsIter.ForEach(func(tag *object.Tag) error {
+			err := checkContext(ctx)
+			if err != nil {
+				return err
+			}
+
 			commit, err := tag.Commit()
 			if err != nil {
 				return err
@@ -511,3 +1042,28 @@ func (r *Repo) tags(repo *git.Repository) (map[string][]string, error) {
 
 	return tags, nil
 }
+
+// newDirStorage returns storage and worktree of the repository stored in dir.
+func newDirStorage(dir string) (storage.Storer, billy.Filesystem) {
+	worktree := osfs.New(dir)
+	fs := osfs.New(filepath.Join(dir, ".git"))
+	storer := filesystem.NewStorageWithOptions(fs, cache.NewObjectLRUDefault(), filesystem.Options{})
+
+	return storer, worktree
+}
+
+// open returns the repository opened from storage. The opened repository is
+// cached. The caller must hold r.mu.
+func (r *Repo) open() (*git.Repository, error) {
+	if r.repo != nil {
+		return r.repo, nil
+	}
+
+	repo, err := git.Open(r.storage, r.worktree)
+	if err != nil {
+		return nil, err
+	}
+	r.repo = repo
+
+	return repo, nil
+}
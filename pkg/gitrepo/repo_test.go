@@ -5,17 +5,24 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-errors/errors"
 
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -101,14 +108,125 @@ func Test_Repo_EnsureUpToDate_nosuchrepo(t *testing.T) {
 		t.Fatalf("err = %v, want %v", err, RepositoryNotFoundError{})
 	}
 
-	// Even if clone fails the first time, it's leaking the directory on the filesystem.
-	// Ensure we keep getting a RepositoryNotFoundError once repo is on the filesystem.
+	// The failed clone is removed from the filesystem. Ensure we keep
+	// getting a RepositoryNotFoundError when cloning again.
 	err = repo.EnsureUpToDate(ctx)
 	if !errors.Is(err, &RepositoryNotFoundError{}) {
 		t.Fatalf("err = %v, want %v", err, RepositoryNotFoundError{})
 	}
 }
 
+// Test_Repo_EnsureUpToDate_canceled tests that EnsureUpToDate returns
+// CanceledError when the remote hangs longer than the context deadline or the
+// context is already canceled.
+func Test_Repo_EnsureUpToDate_canceled(t *testing.T) {
+	t.Parallel()
+
+	// Start a server which accepts connections but never responds.
+	var url string
+	{
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+		t.Cleanup(func() { _ = l.Close() })
+
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() { _, _ = io.Copy(io.Discard, conn) }()
+			}
+		}()
+
+		url = fmt.Sprintf("http://%s/hanging.git", l.Addr())
+	}
+
+	testCases := []struct {
+		name          string
+		ctx           func() (context.Context, context.CancelFunc)
+		expectedCause error
+	}{
+		{
+			name: "case 0: deadline exceeded",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 200*time.Millisecond)
+			},
+			expectedCause: context.DeadlineExceeded,
+		},
+		{
+			name: "case 1: canceled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			expectedCause: context.Canceled,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			c := Config{
+				Dir: filepath.Join(t.TempDir(), "clone"),
+				URL: url,
+			}
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			doneCh := make(chan struct{})
+			go func() {
+				err = repo.EnsureUpToDate(ctx)
+				close(doneCh)
+			}()
+
+			select {
+			case <-time.After(15 * time.Second):
+				t.Fatalf("timeout after %v", 15*time.Second)
+			case <-doneCh:
+				if !errors.Is(err, &CanceledError{}) {
+					t.Fatalf("err = %v, want %v", err, &CanceledError{})
+				}
+				if !errors.Is(err, tc.expectedCause) {
+					t.Fatalf("err = %v, want %v", err, tc.expectedCause)
+				}
+			}
+		})
+	}
+}
+
+// Test_Repo_ResolveVersion_canceled tests that ResolveVersion returns
+// CanceledError when the context is canceled.
+func Test_Repo_ResolveVersion_canceled(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestOrigin(t)
+	c0 := origin.commit(nil, nil, "c0", time.Now())
+	origin.branch("master", c0)
+
+	repo := origin.clone(Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.ResolveVersion(ctx, "master")
+	if !errors.Is(err, &CanceledError{}) {
+		t.Fatalf("err = %v, want %v", err, &CanceledError{})
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
 // Test_Repo_Head tests Repo.HeadBranch, Repo.HeadSHA and Repo.HeadTag methods.
 func Test_Repo_Head(t *testing.T) {
 	ctx := context.Background()
@@ -475,6 +593,429 @@ func Test_Repo_ResolveVersion(t *testing.T) {
 	}
 }
 
+// Test_Repo_ResolveVersion_goFormat tests Repo.ResolveVersion method with
+// VersionFormatGo against a local repository.
+func Test_Repo_ResolveVersion_goFormat(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestOrigin(t)
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, map[string]string{"a": "0"}, "c0", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"a": "1"}, "c1", t0.Add(1*time.Hour))
+	c2 := origin.commit([]plumbing.Hash{c1}, map[string]string{"a": "2"}, "c2", t0.Add(2*time.Hour))
+	c3 := origin.commit([]plumbing.Hash{c1}, map[string]string{"b": "3"}, "c3", t0.Add(3*time.Hour))
+	// Committer time in a different time zone must be converted to UTC.
+	c4 := origin.commit([]plumbing.Hash{c3}, map[string]string{"b": "4"}, "c4", t0.Add(4*time.Hour).In(time.FixedZone("CET", 3600)))
+	origin.tag("v1.2.3", c1)
+	origin.tag("v2.0.0-rc.1", c3)
+	origin.branch("rc", c4)
+	origin.branch("master", c2)
+
+	testCases := []struct {
+		name            string
+		inputRef        string
+		expectedVersion string
+	}{
+		{
+			name:            "case 0: untagged commit without tagged parent",
+			inputRef:        c0.String(),
+			expectedVersion: "v0.0.0-20200102030405-" + c0.String()[:12],
+		},
+		{
+			name:            "case 1: tagged commit",
+			inputRef:        c1.String(),
+			expectedVersion: "v1.2.3",
+		},
+		{
+			name:            "case 2: untagged commit with release tagged parent",
+			inputRef:        "master",
+			expectedVersion: "v1.2.4-0.20200102050405-" + c2.String()[:12],
+		},
+		{
+			name:            "case 3: pre-release tagged commit",
+			inputRef:        "v2.0.0-rc.1",
+			expectedVersion: "v2.0.0-rc.1",
+		},
+		{
+			name:            "case 4: untagged commit with pre-release tagged parent",
+			inputRef:        c4.String(),
+			expectedVersion: "v2.0.0-rc.1.0.20200102070405-" + c4.String()[:12],
+		},
+	}
+
+	repo := origin.clone(Config{VersionFormat: VersionFormatGo})
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			version, err := repo.ResolveVersion(context.Background(), tc.inputRef)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
+
+// Test_Repo_ResolveVersion_versionPaths tests Repo.ResolveVersion method with
+// path-scoped versioning against a local monorepo.
+func Test_Repo_ResolveVersion_versionPaths(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestOrigin(t)
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, map[string]string{"module-a/x": "0", "module-b/y": "0"}, "c0", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"module-a/x": "1"}, "c1", t0.Add(1*time.Hour))
+	c2 := origin.commit([]plumbing.Hash{c1}, map[string]string{"module-b/y": "1"}, "c2", t0.Add(2*time.Hour))
+	c3 := origin.commit([]plumbing.Hash{c2}, map[string]string{"module-b/y": "2"}, "c3", t0.Add(3*time.Hour))
+	f1 := origin.commit([]plumbing.Hash{c1}, map[string]string{"module-b/z": "1"}, "f1", t0.Add(4*time.Hour))
+	m := origin.commit([]plumbing.Hash{c3, f1}, map[string]string{"module-b/z": "1"}, "m", t0.Add(5*time.Hour))
+	r0 := origin.commit([]plumbing.Hash{m}, map[string]string{"module-a/x": "2"}, "feat: x", t0.Add(6*time.Hour))
+	r1 := origin.commit([]plumbing.Hash{r0}, map[string]string{"CHANGELOG.md": "1"}, "release", t0.Add(7*time.Hour))
+	g0 := origin.commit([]plumbing.Hash{m}, map[string]string{"docs/readme": "1"}, "g0", t0.Add(8*time.Hour))
+	mg := origin.commit([]plumbing.Hash{r0, g0}, map[string]string{"docs/readme": "1"}, "merge", t0.Add(9*time.Hour))
+	h := origin.commit([]plumbing.Hash{mg}, map[string]string{"CHANGELOG.md": "2"}, "h", t0.Add(10*time.Hour))
+	origin.tag("module-a/v0.1.0", c1)
+	origin.tag("module-b/v1.0.0", c2)
+	origin.tag("module-a/v1.0.0", r1)
+	origin.tag("module-a/v1.1.0", mg)
+	origin.branch("feature", f1)
+	origin.branch("master", m)
+	origin.branch("release", r1)
+	origin.branch("merged", h)
+
+	testCases := []struct {
+		name            string
+		inputPaths      []string
+		inputTagPrefix  string
+		inputRef        string
+		expectedVersion string
+		expectedError   error
+	}{
+		{
+			name:            "case 0: module unchanged since its tag",
+			inputPaths:      []string{"module-a"},
+			inputTagPrefix:  "module-a",
+			inputRef:        "master",
+			expectedVersion: "0.1.0",
+		},
+		{
+			name:            "case 1: module unchanged since its tag on older commit",
+			inputPaths:      []string{"/module-a/"},
+			inputTagPrefix:  "module-a",
+			inputRef:        c3.String(),
+			expectedVersion: "0.1.0",
+		},
+		{
+			name:            "case 2: tagged module commit",
+			inputPaths:      []string{"module-b"},
+			inputTagPrefix:  "module-b",
+			inputRef:        c2.String(),
+			expectedVersion: "1.0.0",
+		},
+		{
+			name:            "case 3: module changed after its tag",
+			inputPaths:      []string{"module-b"},
+			inputTagPrefix:  "module-b",
+			inputRef:        c3.String(),
+			expectedVersion: "1.0.0-" + c3.String(),
+		},
+		{
+			name:            "case 4: merge commit changing the module",
+			inputPaths:      []string{"module-b"},
+			inputTagPrefix:  "module-b",
+			inputRef:        "master",
+			expectedVersion: "1.0.0-" + m.String(),
+		},
+		{
+			name:            "case 5: module not changed since root commit",
+			inputPaths:      []string{"module-b"},
+			inputTagPrefix:  "module-b",
+			inputRef:        c1.String(),
+			expectedVersion: "0.0.0-" + c0.String(),
+		},
+		{
+			name:            "case 6: multiple paths",
+			inputPaths:      []string{"module-a", "module-b/z"},
+			inputTagPrefix:  "module-a",
+			inputRef:        "master",
+			expectedVersion: "0.1.0-" + f1.String(),
+		},
+		{
+			name:           "case 7: path never touched",
+			inputPaths:     []string{"module-c"},
+			inputTagPrefix: "module-c",
+			inputRef:       "master",
+			expectedError:  &ReferenceNotFoundError{},
+		},
+		{
+			name:            "case 8: tag on release commit not touching the paths",
+			inputPaths:      []string{"module-a"},
+			inputTagPrefix:  "module-a",
+			inputRef:        "release",
+			expectedVersion: "1.0.0",
+		},
+		{
+			name:            "case 9: tag on merge commit not touching the paths",
+			inputPaths:      []string{"module-a"},
+			inputTagPrefix:  "module-a",
+			inputRef:        "merged",
+			expectedVersion: "1.1.0",
+		},
+		{
+			name:            "case 10: paths changed after tag on merge commit",
+			inputPaths:      []string{"CHANGELOG.md"},
+			inputTagPrefix:  "module-a",
+			inputRef:        "merged",
+			expectedVersion: "1.1.0-" + h.String(),
+		},
+	}
+
+	dir := t.TempDir()
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			c := Config{
+				Dir:          dir,
+				URL:          origin.dir,
+				VersionPaths: tc.inputPaths,
+				TagPrefix:    tc.inputTagPrefix,
+			}
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			err = repo.EnsureUpToDate(context.Background())
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			version, err := repo.ResolveVersion(context.Background(), tc.inputRef)
+
+			switch {
+			case err == nil && tc.expectedError == nil:
+				// correct; carry on
+			case err != nil && tc.expectedError == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.expectedError != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case reflect.TypeOf(tc.expectedError) != reflect.TypeOf(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+		})
+	}
+
+	// Paths must point inside the repository.
+	{
+		c := Config{
+			Dir:          dir,
+			URL:          origin.dir,
+			VersionPaths: []string{"/"},
+		}
+		_, err := New(c)
+		if !errors.Is(err, &InvalidConfigError{}) {
+			t.Fatalf("err = %v, want %v", err, &InvalidConfigError{})
+		}
+	}
+}
+
+// Test_Repo_ResolveVersion_tagPattern tests Repo.ResolveVersion method with
+// tag prefix and version tag pattern configured for multiple modules of the
+// same repository in one process.
+func Test_Repo_ResolveVersion_tagPattern(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestOrigin(t)
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, nil, "c0", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, nil, "c1", t0.Add(1*time.Hour))
+	c2 := origin.commit([]plumbing.Hash{c1}, nil, "c2", t0.Add(2*time.Hour))
+	origin.tag("v9.9.9", c0)
+	origin.tag("release-1.2.3", c0)
+	origin.tag("chart-name-0.4.0", c1)
+	origin.tag("module-a/release-2.0.0", c1)
+	origin.tag("module-a/v3.0.0", c2)
+	origin.branch("master", c2)
+
+	testCases := []struct {
+		name              string
+		tagPrefix         string
+		versionTagPattern string
+		expectedVersion   string
+	}{
+		{
+			name:            "case 0: default pattern",
+			expectedVersion: "9.9.9-" + c2.String(),
+		},
+		{
+			name:              "case 1: release pattern",
+			versionTagPattern: `^release-(?P<version>\d+\.\d+\.\d+)$`,
+			expectedVersion:   "1.2.3-" + c2.String(),
+		},
+		{
+			name:              "case 2: chart pattern",
+			versionTagPattern: `^chart-name-(?P<version>.+)$`,
+			expectedVersion:   "0.4.0-" + c2.String(),
+		},
+		{
+			name:              "case 3: release pattern with prefix",
+			tagPrefix:         "module-a",
+			versionTagPattern: `^release-(?P<version>\d+\.\d+\.\d+)$`,
+			expectedVersion:   "2.0.0-" + c2.String(),
+		},
+		{
+			name:            "case 4: default pattern with prefix",
+			tagPrefix:       "module-a",
+			expectedVersion: "3.0.0",
+		},
+	}
+
+	dir := origin.clone(Config{}).worktree.Root()
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			c := Config{
+				Dir:               dir,
+				TagPrefix:         tc.tagPrefix,
+				VersionTagPattern: tc.versionTagPattern,
+			}
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			version, err := repo.ResolveVersion(context.Background(), "master")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
+
+// Test_Repo_ResolveVersion_versionStrategy tests Repo.ResolveVersion method
+// with different version strategies on diverging release branches.
+func Test_Repo_ResolveVersion_versionStrategy(t *testing.T) {
+	t.Parallel()
+
+	origin := newTestOrigin(t)
+
+	// master: c0 (v1.0.0) -> a1 (v1.5.0) -> m -> h where m also merges
+	// the release branch c0 -> b1 (v1.2.1), a hotfix committed after a1.
+	//
+	// next: c0 -> s1 (v2.0.0-rc.1, committed with a skewed clock before
+	// c0) -> s2 -> sm -> sh where sm also merges a1.
+	//
+	// maintenance: c0 -> p1 (v1.9.0) -> p2 (v1.6.0) -> p3.
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, nil, "c0", t0)
+	a1 := origin.commit([]plumbing.Hash{c0}, nil, "a1", t0.Add(1*time.Hour))
+	b1 := origin.commit([]plumbing.Hash{c0}, nil, "b1", t0.Add(3*time.Hour))
+	m := origin.commit([]plumbing.Hash{a1, b1}, nil, "m", t0.Add(4*time.Hour))
+	h := origin.commit([]plumbing.Hash{m}, nil, "h", t0.Add(5*time.Hour))
+	s1 := origin.commit([]plumbing.Hash{c0}, nil, "s1", t0.Add(-time.Hour))
+	s2 := origin.commit([]plumbing.Hash{s1}, nil, "s2", t0.Add(6*time.Hour))
+	sm := origin.commit([]plumbing.Hash{s2, a1}, nil, "sm", t0.Add(7*time.Hour))
+	sh := origin.commit([]plumbing.Hash{sm}, nil, "sh", t0.Add(8*time.Hour))
+	p1 := origin.commit([]plumbing.Hash{c0}, nil, "p1", t0.Add(9*time.Hour))
+	p2 := origin.commit([]plumbing.Hash{p1}, nil, "p2", t0.Add(10*time.Hour))
+	p3 := origin.commit([]plumbing.Hash{p2}, nil, "p3", t0.Add(11*time.Hour))
+	origin.tag("v1.0.0", c0)
+	origin.tag("v1.5.0", a1)
+	origin.tag("v1.2.1", b1)
+	origin.tag("v2.0.0-rc.1", s1)
+	origin.tag("v1.9.0", p1)
+	origin.tag("v1.6.0", p2)
+	origin.branch("next", sh)
+	origin.branch("maintenance", p3)
+	origin.branch("master", h)
+
+	testCases := []struct {
+		name            string
+		inputStrategy   VersionStrategy
+		inputRef        string
+		expectedVersion string
+	}{
+		{
+			name:            "case 0: most recent after merge of release branch",
+			inputStrategy:   VersionStrategyMostRecent,
+			inputRef:        "master",
+			expectedVersion: "1.2.1-" + h.String(),
+		},
+		{
+			name:            "case 1: highest after merge of release branch",
+			inputStrategy:   VersionStrategyHighest,
+			inputRef:        "master",
+			expectedVersion: "1.5.0-" + h.String(),
+		},
+		{
+			name:            "case 2: most recent with skewed clock",
+			inputStrategy:   VersionStrategyMostRecent,
+			inputRef:        "origin/next",
+			expectedVersion: "1.5.0-" + sh.String(),
+		},
+		{
+			name:            "case 3: highest with skewed clock and pre-release",
+			inputStrategy:   VersionStrategyHighest,
+			inputRef:        "origin/next",
+			expectedVersion: "2.0.0-rc.1-" + sh.String(),
+		},
+		{
+			name:            "case 4: highest does not look past tagged commits",
+			inputStrategy:   VersionStrategyHighest,
+			inputRef:        "origin/maintenance",
+			expectedVersion: "1.6.0-" + p3.String(),
+		},
+	}
+
+	dir := origin.clone(Config{}).worktree.Root()
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			c := Config{
+				Dir:             dir,
+				VersionStrategy: tc.inputStrategy,
+			}
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			version, err := repo.ResolveVersion(context.Background(), tc.inputRef)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
+
 // Test_Repo_GetFileContent tests Repo.GetFileContent method which retrieves
 // the content of a file.
 //
@@ -687,6 +1228,341 @@ func Test_Repo_GetFolderContent(t *testing.T) {
 	}
 }
 
+// Test_Repo_GetFileContent_worktreeUntouched tests that Repo.GetFileContent
+// and Repo.GetFolderContent read content of other refs without changing the
+// worktree and HEAD, also when called in parallel.
+func Test_Repo_GetFileContent_worktreeUntouched(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	origin := newTestOrigin(t)
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, map[string]string{"file": "c0", "dir/nested": "c0"}, "c0", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1", "other": "c1"}, "c1", t0.Add(time.Hour))
+	origin.branch("master", c1)
+
+	repo := origin.clone(Config{})
+
+	untracked := filepath.Join(repo.worktree.Root(), "untracked")
+	{
+		err := os.WriteFile(untracked, []byte("untracked"), 0600)
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		ref             string
+		path            string
+		expectedContent string
+		expectedFiles   []string
+	}{
+		{
+			name:            "case 0: file on default branch",
+			path:            "file",
+			expectedContent: "c1",
+		},
+		{
+			name:            "case 1: file on older commit",
+			ref:             c0.String(),
+			path:            "/file",
+			expectedContent: "c0",
+		},
+		{
+			name:            "case 2: nested file on older commit",
+			ref:             c0.String(),
+			path:            "dir/nested",
+			expectedContent: "c0",
+		},
+		{
+			name:          "case 3: root folder on older commit",
+			ref:           c0.String(),
+			path:          ".",
+			expectedFiles: []string{"dir", "file"},
+		},
+		{
+			name:          "case 4: root folder on branch",
+			ref:           "origin/master",
+			path:          "/",
+			expectedFiles: []string{"dir", "file", "other"},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			for j := 0; j < 10; j++ {
+				if tc.expectedFiles != nil {
+					files, err := repo.GetFolderContent(tc.path, tc.ref)
+					if err != nil {
+						t.Fatalf("err = %v, want %v", err, nil)
+					}
+
+					var names []string
+					for _, f := range files {
+						names = append(names, f.Name())
+						if f.Name() == "dir" && !f.IsDir() {
+							t.Fatalf("%s.IsDir() = false, want true", f.Name())
+						}
+					}
+					if !reflect.DeepEqual(names, tc.expectedFiles) {
+						t.Fatalf("files = %v, want %v", names, tc.expectedFiles)
+					}
+				} else {
+					content, err := repo.GetFileContent(tc.path, tc.ref)
+					if err != nil {
+						t.Fatalf("err = %v, want %v", err, nil)
+					}
+					if string(content) != tc.expectedContent {
+						t.Fatalf("content = %q, want %q", content, tc.expectedContent)
+					}
+				}
+			}
+		})
+	}
+
+	t.Cleanup(func() {
+		sha, err := repo.HeadSHA(ctx)
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+		if sha != c1.String() {
+			t.Fatalf("sha = %s, want %s", sha, c1)
+		}
+
+		_, err = os.Stat(untracked)
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+
+		content, err := os.ReadFile(filepath.Join(repo.worktree.Root(), "file"))
+		if err != nil {
+			t.Fatalf("err = %v, want %v", err, nil)
+		}
+		if string(content) != "c1" {
+			t.Fatalf("content = %q, want %q", content, "c1")
+		}
+	})
+}
+
+// Test_New_storage tests validation of storage related Config fields.
+func Test_New_storage(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		config        Config
+		expectedError error
+	}{
+		{
+			name:   "case 0: dir",
+			config: Config{Dir: "/tmp/gitrepo-test-new-storage", URL: "https://example.com/repo.git"},
+		},
+		{
+			name:   "case 1: in memory",
+			config: Config{InMemory: true, URL: "https://example.com/repo.git"},
+		},
+		{
+			name:   "case 2: custom storer and worktree",
+			config: Config{Storer: memory.NewStorage(), Worktree: memfs.New(), URL: "https://example.com/repo.git"},
+		},
+		{
+			name:          "case 3: nothing set",
+			config:        Config{URL: "https://example.com/repo.git"},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 4: dir and in memory",
+			config:        Config{Dir: "/tmp/gitrepo-test-new-storage", InMemory: true, URL: "https://example.com/repo.git"},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 5: storer without worktree",
+			config:        Config{Storer: memory.NewStorage(), URL: "https://example.com/repo.git"},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 6: in memory and storer",
+			config:        Config{InMemory: true, Storer: memory.NewStorage(), Worktree: memfs.New(), URL: "https://example.com/repo.git"},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 7: in memory without URL",
+			config:        Config{InMemory: true},
+			expectedError: &InvalidConfigError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			_, err := New(tc.config)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("err = %v, want %v", err, tc.expectedError)
+			}
+		})
+	}
+}
+
+// Test_Repo_inMemory tests that repositories stored in memory or in custom
+// storage support the same operations as the ones stored on disk.
+func Test_Repo_inMemory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	origin := newTestOrigin(t)
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, map[string]string{"file": "c0", "dir/nested": "c0"}, "c0", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1"}, "c1", t0.Add(time.Hour))
+	origin.tag("v1.2.3", c0)
+	origin.branch("master", c1)
+
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "case 0: in memory",
+			config: Config{InMemory: true},
+		},
+		{
+			name:   "case 1: custom storer and worktree",
+			config: Config{Storer: memory.NewStorage(), Worktree: memfs.New()},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			c := tc.config
+			c.URL = origin.dir
+
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			err = repo.EnsureUpToDate(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			version, err := repo.ResolveVersion(ctx, "master")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if expected := "1.2.3-" + c1.String(); version != expected {
+				t.Fatalf("version = %q, want %q", version, expected)
+			}
+
+			content, err := repo.GetFileContent("dir/nested", "")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if string(content) != "c0" {
+				t.Fatalf("content = %q, want %q", content, "c0")
+			}
+
+			files, err := repo.GetFolderContent("/", "v1.2.3")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if !containsFile(files, "dir") || !containsFile(files, "file") {
+				t.Fatalf("files = %v, want %v", files, []string{"dir", "file"})
+			}
+
+			sha, err := repo.HeadSHA(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if sha != c1.String() {
+				t.Fatalf("sha = %s, want %s", sha, c1)
+			}
+		})
+	}
+}
+
+// Test_Repo_concurrent tests that Repo methods can be called concurrently.
+// Run it with -race to detect unsynchronized access.
+func Test_Repo_concurrent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	origin := newTestOrigin(t)
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+	c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1"}, "c1", t0.Add(time.Hour))
+	origin.tag("v1.0.0", c0)
+	origin.tag("v1.1.0", c1)
+	origin.branch("master", c1)
+
+	// In memory storage has no synchronization of its own so
+	// unsynchronized access is reported by the race detector.
+	repo, err := New(Config{URL: origin.dir, InMemory: true})
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	refs := map[string]plumbing.Hash{
+		"v1.0.0": c0,
+		"v1.1.0": c1,
+		"master": c1,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for ref, hash := range refs {
+			wg.Add(1)
+			go func(ref string, hash plumbing.Hash) {
+				defer wg.Done()
+
+				err := repo.EnsureUpToDate(ctx)
+				if err != nil {
+					t.Errorf("err = %v, want %v", err, nil)
+					return
+				}
+
+				content, err := repo.GetFileContent("file", ref)
+				if err != nil {
+					t.Errorf("err = %v, want %v", err, nil)
+					return
+				}
+				if expected := map[plumbing.Hash]string{c0: "c0", c1: "c1"}[hash]; string(content) != expected {
+					t.Errorf("content = %q, want %q", content, expected)
+				}
+
+				_, err = repo.GetFolderContent("/", ref)
+				if err != nil {
+					t.Errorf("err = %v, want %v", err, nil)
+					return
+				}
+
+				_, err = repo.ResolveVersion(ctx, ref)
+				if err != nil {
+					t.Errorf("err = %v, want %v", err, nil)
+					return
+				}
+
+				_, err = repo.HeadSHA(ctx)
+				if err != nil {
+					t.Errorf("err = %v, want %v", err, nil)
+					return
+				}
+			}(ref, hash)
+		}
+	}
+	wg.Wait()
+}
+
 func containsFile(files []os.FileInfo, fileName string) bool {
 	for _, f := range files {
 		if f.Name() == fileName {
@@ -696,3 +1572,126 @@ func containsFile(files []os.FileInfo, fileName string) bool {
 
 	return false
 }
+
+// testOrigin is a repository created in a temporary directory. It is used as
+// a remote for tests which must not depend on network access.
+type testOrigin struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+func newTestOrigin(t *testing.T) *testOrigin {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	o := &testOrigin{
+		t:    t,
+		dir:  dir,
+		repo: repo,
+	}
+
+	return o
+}
+
+// commit creates a commit with the given parents. The tree of the commit is
+// the tree of the first parent with files added or overwritten. When there
+// are no parents the commit is created on top of HEAD.
+func (o *testOrigin) commit(parents []plumbing.Hash, files map[string]string, message string, when time.Time) plumbing.Hash {
+	o.t.Helper()
+
+	worktree, err := o.repo.Worktree()
+	if err != nil {
+		o.t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	if len(parents) > 0 {
+		err = worktree.Checkout(&git.CheckoutOptions{Hash: parents[0], Force: true})
+		if err != nil {
+			o.t.Fatalf("err = %v, want %v", err, nil)
+		}
+	}
+
+	for path, content := range files {
+		err = util.WriteFile(worktree.Filesystem, path, []byte(content), 0644)
+		if err != nil {
+			o.t.Fatalf("err = %v, want %v", err, nil)
+		}
+
+		_, err = worktree.Add(path)
+		if err != nil {
+			o.t.Fatalf("err = %v, want %v", err, nil)
+		}
+	}
+
+	signature := &object.Signature{
+		Name:  "gitrepo-test",
+		Email: "gitrepo-test@example.com",
+		When:  when,
+	}
+
+	hash, err := worktree.Commit(message, &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            signature,
+		Committer:         signature,
+		Parents:           parents,
+	})
+	if err != nil {
+		o.t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	return hash
+}
+
+// branch points the branch with the given name at hash and makes it the HEAD
+// of the origin.
+func (o *testOrigin) branch(name string, hash plumbing.Hash) {
+	o.t.Helper()
+
+	err := o.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), hash))
+	if err != nil {
+		o.t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	err = o.repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(name)))
+	if err != nil {
+		o.t.Fatalf("err = %v, want %v", err, nil)
+	}
+}
+
+// tag creates a lightweight tag with the given name pointing at hash.
+func (o *testOrigin) tag(name string, hash plumbing.Hash) {
+	o.t.Helper()
+
+	_, err := o.repo.CreateTag(name, hash, nil)
+	if err != nil {
+		o.t.Fatalf("err = %v, want %v", err, nil)
+	}
+}
+
+// clone creates Repo for the origin in a temporary directory and fetches it.
+// Dir and URL of the given config are overwritten.
+func (o *testOrigin) clone(c Config) *Repo {
+	o.t.Helper()
+
+	c.Dir = filepath.Join(o.t.TempDir(), "clone")
+	c.URL = o.dir
+
+	repo, err := New(c)
+	if err != nil {
+		o.t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	err = repo.EnsureUpToDate(context.Background())
+	if err != nil {
+		o.t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	return repo
+}
@@ -0,0 +1,227 @@
+package gitrepo
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// UpdateReport describes references changed by EnsureUpToDateWithReport.
+type UpdateReport struct {
+	// Cloned is true when the repository was cloned by the call.
+	Cloned bool
+	// Recovered is true when the existing repository failed the
+	// integrity check and was cloned again. See Config.RecoverCorrupted.
+	Recovered bool
+	// RecoveredFrom is the RepositoryCorruptedError the repository was
+	// recovered from.
+	RecoveredFrom error
+	// Updates are the changed references sorted by name.
+	Updates []RefUpdate
+	// NewTags are short names of created tags, e.g. "v1.2.3", sorted
+	// alphabetically.
+	NewTags []string
+	// Transfer describes data received by the clone or fetch.
+	Transfer TransferStats
+}
+
+// RefUpdate describes a single changed reference.
+type RefUpdate struct {
+	// Name is the full reference name, e.g. "refs/remotes/origin/master"
+	// or "refs/tags/v1.2.3".
+	Name string
+	// OldSHA is the SHA of the commit the reference pointed to before the
+	// update. It is empty when the reference is created.
+	OldSHA string
+	// NewSHA is the SHA of the commit the reference points to after the
+	// update. It is empty when the reference is deleted.
+	NewSHA string
+	// Created is true when the reference did not exist before.
+	Created bool
+	// Deleted is true when the reference was removed by pruning.
+	Deleted bool
+	// Forced is true when a branch moved to a commit which does not
+	// descend from the previous one, e.g. after a force push, or a tag
+	// moved.
+	Forced bool
+}
+
+// Changed returns true when any reference changed.
+func (r *UpdateReport) Changed() bool {
+	return len(r.Updates) > 0
+}
+
+// Ref returns the update of the reference with the full name, e.g.
+// "refs/remotes/origin/master". It returns false if the reference did not
+// change.
+func (r *UpdateReport) Ref(name string) (RefUpdate, bool) {
+	for _, u := range r.Updates {
+		if u.Name == name {
+			return u, true
+		}
+	}
+
+	return RefUpdate{}, false
+}
+
+// Branches returns updates of remote-tracking branches.
+func (r *UpdateReport) Branches() []RefUpdate {
+	return r.filter(plumbing.ReferenceName.IsRemote)
+}
+
+// Tags returns updates of tags.
+func (r *UpdateReport) Tags() []RefUpdate {
+	return r.filter(plumbing.ReferenceName.IsTag)
+}
+
+func (r *UpdateReport) filter(f func(plumbing.ReferenceName) bool) []RefUpdate {
+	var updates []RefUpdate
+	for _, u := range r.Updates {
+		if f(plumbing.ReferenceName(u.Name)) {
+			updates = append(updates, u)
+		}
+	}
+
+	return updates
+}
+
+// refSnapshot returns hashes of all the non-symbolic references in the
+// storage by reference name.
+func refSnapshot(s storer.ReferenceStorer) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	iter, err := s.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	refs := map[plumbing.ReferenceName]plumbing.Hash{}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			refs[ref.Name()] = ref.Hash()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// newUpdateReport compares reference snapshots taken before and after
+// a fetch.
+func newUpdateReport(ctx context.Context, repo *git.Repository, before, after map[plumbing.ReferenceName]plumbing.Hash) (*UpdateReport, error) {
+	report := &UpdateReport{}
+
+	for name, newHash := range after {
+		oldHash, ok := before[name]
+		if ok && oldHash == newHash {
+			continue
+		}
+
+		u := RefUpdate{
+			Name:    name.String(),
+			Created: !ok,
+		}
+
+		var err error
+		u.NewSHA, err = peel(repo, newHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			u.OldSHA, err = peel(repo, oldHash)
+			if err != nil {
+				return nil, err
+			}
+
+			u.Forced, err = isForcedUpdate(ctx, repo, name, oldHash, newHash)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if u.Created && name.IsTag() {
+			report.NewTags = append(report.NewTags, name.Short())
+		}
+
+		report.Updates = append(report.Updates, u)
+	}
+
+	for name, oldHash := range before {
+		if _, ok := after[name]; ok {
+			continue
+		}
+
+		oldSHA, err := peel(repo, oldHash)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Updates = append(report.Updates, RefUpdate{
+			Name:    name.String(),
+			OldSHA:  oldSHA,
+			Deleted: true,
+		})
+	}
+
+	sort.Slice(report.Updates, func(i, j int) bool { return report.Updates[i].Name < report.Updates[j].Name })
+	sort.Strings(report.NewTags)
+
+	return report, nil
+}
+
+// peel returns the SHA of the commit an annotated tag with the hash points
+// to, or the hash itself for other objects. Objects which are not stored
+// anymore are returned as they are.
+func peel(repo *git.Repository, hash plumbing.Hash) (string, error) {
+	tag, err := repo.TagObject(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return hash.String(), nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return tag.Target.String(), nil
+}
+
+// isForcedUpdate returns true when the reference moved from oldHash to
+// a commit which does not descend from it. Moved tags are always forced.
+func isForcedUpdate(ctx context.Context, repo *git.Repository, name plumbing.ReferenceName, oldHash, newHash plumbing.Hash) (bool, error) {
+	err := checkContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if name.IsTag() {
+		return true, nil
+	}
+
+	oldCommit, err := repo.CommitObject(oldHash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	newCommit, err := repo.CommitObject(newHash)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := oldCommit.IsAncestor(newCommit)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		// Shallow history does not tell, assume fast-forward.
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return !ok, nil
+}
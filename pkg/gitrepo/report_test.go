@@ -0,0 +1,186 @@
+package gitrepo
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// Test_Repo_EnsureUpToDateWithReport tests that references added, updated,
+// moved and deleted in the remote are fetched, optionally pruned, and
+// reported.
+func Test_Repo_EnsureUpToDateWithReport(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// SHAs are commit names replaced with the actual SHAs.
+	testCases := []struct {
+		name            string
+		config          Config
+		expectedUpdates []RefUpdate
+		expectedTags    map[string]int
+	}{
+		{
+			name: "case 0: without prune",
+			expectedUpdates: []RefUpdate{
+				{Name: "refs/remotes/origin/master", OldSHA: "c1", NewSHA: "c2"},
+				{Name: "refs/remotes/origin/new", NewSHA: "c0", Created: true},
+				{Name: "refs/remotes/origin/other", OldSHA: "c1", NewSHA: "o1", Forced: true},
+				{Name: "refs/tags/v1.0.0", OldSHA: "c0", NewSHA: "c1", Forced: true},
+				{Name: "refs/tags/v2.0.0", NewSHA: "c2", Created: true},
+			},
+			expectedTags: map[string]int{"v1.0.0": 1, "v1.1.0": 1, "v2.0.0": 2},
+		},
+		{
+			name:   "case 1: with prune",
+			config: Config{Prune: true},
+			expectedUpdates: []RefUpdate{
+				{Name: "refs/remotes/origin/gone", OldSHA: "c0", Deleted: true},
+				{Name: "refs/remotes/origin/master", OldSHA: "c1", NewSHA: "c2"},
+				{Name: "refs/remotes/origin/new", NewSHA: "c0", Created: true},
+				{Name: "refs/remotes/origin/other", OldSHA: "c1", NewSHA: "o1", Forced: true},
+				{Name: "refs/tags/v1.0.0", OldSHA: "c0", NewSHA: "c1", Forced: true},
+				{Name: "refs/tags/v1.1.0", OldSHA: "c1", Deleted: true},
+				{Name: "refs/tags/v2.0.0", NewSHA: "c2", Created: true},
+			},
+			expectedTags: map[string]int{"v1.0.0": 1, "v2.0.0": 2},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, nil, "c0", t0)
+			c1 := origin.commit([]plumbing.Hash{c0}, nil, "c1", t0.Add(time.Hour))
+			origin.tag("v1.0.0", c0)
+			origin.tag("v1.1.0", c1)
+			origin.branch("gone", c0)
+			origin.branch("other", c1)
+			origin.branch("master", c1)
+
+			c := tc.config
+			c.Dir = filepath.Join(t.TempDir(), "clone")
+			c.URL = origin.dir
+
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			report, err := repo.EnsureUpToDateWithReport(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if !report.Cloned || !report.Changed() {
+				t.Fatalf("report = %#v, want cloned and changed", report)
+			}
+
+			report, err = repo.EnsureUpToDateWithReport(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if report.Cloned || report.Changed() {
+				t.Fatalf("report = %#v, want not cloned and not changed", report)
+			}
+
+			// Change the remote.
+			var hashes []plumbing.Hash
+			shas := map[string]string{"": ""}
+			{
+				c2 := origin.commit([]plumbing.Hash{c1}, nil, "c2", t0.Add(2*time.Hour))
+				o1 := origin.commit([]plumbing.Hash{c0}, nil, "o1", t0.Add(3*time.Hour))
+				hashes = []plumbing.Hash{c0, c1, c2}
+				shas["c0"], shas["c1"], shas["c2"], shas["o1"] = c0.String(), c1.String(), c2.String(), o1.String()
+
+				err = origin.repo.DeleteTag("v1.0.0")
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+				err = origin.repo.DeleteTag("v1.1.0")
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+				err = origin.repo.Storer.RemoveReference(plumbing.NewBranchReferenceName("gone"))
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+
+				origin.tag("v1.0.0", c1)
+				_, err = origin.repo.CreateTag("v2.0.0", c2, &git.CreateTagOptions{
+					Tagger:  &object.Signature{Name: "gitrepo-test", Email: "gitrepo-test@example.com", When: t0},
+					Message: "v2.0.0",
+				})
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+				origin.branch("new", c0)
+				origin.branch("other", o1)
+				origin.branch("master", c2)
+			}
+
+			report, err = repo.EnsureUpToDateWithReport(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			expectedReport := &UpdateReport{
+				NewTags: []string{"v2.0.0"},
+			}
+			for _, u := range tc.expectedUpdates {
+				u.OldSHA, u.NewSHA = shas[u.OldSHA], shas[u.NewSHA]
+				expectedReport.Updates = append(expectedReport.Updates, u)
+			}
+			// Transfer is covered by Test_Repo_EnsureUpToDate_transfer.
+			if diff := cmp.Diff(expectedReport, report, cmpopts.IgnoreFields(UpdateReport{}, "Transfer")); diff != "" {
+				t.Fatalf("report mismatch (-want +got):\n%s", diff)
+			}
+
+			u, ok := report.Ref("refs/remotes/origin/master")
+			if !ok || u.NewSHA != shas["c2"] {
+				t.Fatalf("master = %#v, %v, want new SHA %s", u, ok, shas["c2"])
+			}
+			if n := len(report.Branches()) + len(report.Tags()); n != len(report.Updates) {
+				t.Fatalf("len(branches) + len(tags) = %d, want %d", n, len(report.Updates))
+			}
+
+			tags := map[string]int{}
+			for i, h := range hashes {
+				for _, tag := range mustTags(t, repo)[h.String()] {
+					tags[tag] = i
+				}
+			}
+			if diff := cmp.Diff(tc.expectedTags, tags); diff != "" {
+				t.Fatalf("tags mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func mustTags(t *testing.T, repo *Repo) map[string][]string {
+	t.Helper()
+
+	gitRepo, err := git.Open(repo.storage, repo.worktree)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	tags, err := repo.tags(context.Background(), gitRepo)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	return tags
+}
@@ -0,0 +1,125 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+	defaultRetryJitter     = 0.1
+)
+
+// retryPolicy retries clones and fetches failed with transient errors.
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	jitter      float64
+	retryIf     func(error) bool
+}
+
+func newRetryPolicy(config Config) (retryPolicy, error) {
+	if config.RetryMaxAttempts < 0 {
+		return retryPolicy{}, &InvalidConfigError{message: fmt.Sprintf("%T.RetryMaxAttempts must not be negative", config)}
+	}
+	if config.RetryBackoff < 0 {
+		return retryPolicy{}, &InvalidConfigError{message: fmt.Sprintf("%T.RetryBackoff must not be negative", config)}
+	}
+	if config.RetryMaxBackoff < 0 {
+		return retryPolicy{}, &InvalidConfigError{message: fmt.Sprintf("%T.RetryMaxBackoff must not be negative", config)}
+	}
+	if config.RetryJitter >= 1 {
+		return retryPolicy{}, &InvalidConfigError{message: fmt.Sprintf("%T.RetryJitter must be lower than 1", config)}
+	}
+
+	p := retryPolicy{
+		maxAttempts: config.RetryMaxAttempts,
+		backoff:     config.RetryBackoff,
+		maxBackoff:  config.RetryMaxBackoff,
+		jitter:      config.RetryJitter,
+		retryIf:     config.RetryIf,
+	}
+	if p.maxAttempts == 0 {
+		p.maxAttempts = 1
+	}
+	if p.backoff == 0 {
+		p.backoff = defaultRetryBackoff
+	}
+	if p.maxBackoff == 0 {
+		p.maxBackoff = defaultRetryMaxBackoff
+	}
+	if p.maxBackoff < p.backoff {
+		return retryPolicy{}, &InvalidConfigError{message: fmt.Sprintf("%T.RetryMaxBackoff must not be lower than %T.RetryBackoff", config, config)}
+	}
+	if p.jitter == 0 {
+		p.jitter = defaultRetryJitter
+	}
+	if p.jitter < 0 {
+		p.jitter = 0
+	}
+	if p.retryIf == nil {
+		p.retryIf = IsTransient
+	}
+
+	return p, nil
+}
+
+// do calls op until it succeeds, fails with an error which should not be
+// retried or the maximum number of attempts is reached. The last error is
+// returned. Waiting between attempts is aborted when the context is canceled.
+func (p retryPolicy) do(ctx context.Context, logger *slog.Logger, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || errors.Is(err, &CanceledError{}) {
+			return err
+		}
+		if attempt >= p.maxAttempts || !p.retryIf(err) {
+			return err
+		}
+
+		d := backoff(p.backoff, p.maxBackoff, p.jitter, attempt-1, rand.Float64())
+		logger.WarnContext(ctx, "retrying failed operation",
+			slog.Int("attempt", attempt),
+			slog.Int("max_attempts", p.maxAttempts),
+			slog.Duration("backoff", d),
+			slog.Any("error", err),
+		)
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return contextError(ctx, "retry", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// IsTransient returns true for errors which may not happen again when the
+// operation is retried, i.e. errors matching NetworkError. It is the default
+// Config.RetryIf.
+func IsTransient(err error) bool {
+	return IsNetwork(err)
+}
+
+// backoff returns the time to wait after n consecutive failures. It is base
+// doubled n times up to maxBackoff and randomly shortened or extended by the
+// jitter fraction. f is a random number in [0, 1).
+func backoff(base, maxBackoff time.Duration, jitter float64, n int, f float64) time.Duration {
+	d := base
+	for i := 0; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+
+	return time.Duration(float64(d) * (1 + jitter*(2*f-1)))
+}
@@ -0,0 +1,278 @@
+package gitrepo
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Test_Repo_EnsureUpToDate_retry tests that clones and fetches failed with
+// transient errors are retried against a git HTTP server failing the first
+// requests.
+func Test_Repo_EnsureUpToDate_retry(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name string
+		// fetch makes the failures happen in the fetch of an existing
+		// clone instead of the initial clone.
+		fetch bool
+		// existingDir creates Dir before the clone.
+		existingDir bool
+		failures    int
+		// failPath is the suffix of the failed request paths. When
+		// empty all the requests fail.
+		failPath         string
+		statusCode       int
+		config           Config
+		timeout          time.Duration
+		expectedFailures int
+		expectedError    error
+	}{
+		{
+			name:             "case 0: no retries by default",
+			failures:         1,
+			statusCode:       nethttp.StatusServiceUnavailable,
+			expectedFailures: 1,
+			expectedError:    &NetworkError{},
+		},
+		{
+			name:             "case 1: clone retried",
+			failures:         2,
+			statusCode:       nethttp.StatusServiceUnavailable,
+			config:           Config{RetryMaxAttempts: 3},
+			expectedFailures: 2,
+		},
+		{
+			name:             "case 2: half-failed clone retried",
+			failures:         2,
+			failPath:         "/git-upload-pack",
+			statusCode:       nethttp.StatusBadGateway,
+			config:           Config{RetryMaxAttempts: 3},
+			expectedFailures: 2,
+		},
+		{
+			name:             "case 3: half-failed clone into existing directory retried",
+			existingDir:      true,
+			failures:         2,
+			failPath:         "/git-upload-pack",
+			statusCode:       nethttp.StatusBadGateway,
+			config:           Config{RetryMaxAttempts: 3},
+			expectedFailures: 2,
+		},
+		{
+			name:             "case 4: attempts exhausted",
+			failures:         3,
+			statusCode:       nethttp.StatusServiceUnavailable,
+			config:           Config{RetryMaxAttempts: 3},
+			expectedFailures: 3,
+			expectedError:    &NetworkError{},
+		},
+		{
+			name:             "case 5: permanent error not retried",
+			failures:         2,
+			statusCode:       nethttp.StatusUnauthorized,
+			config:           Config{RetryMaxAttempts: 3},
+			expectedFailures: 1,
+			expectedError:    &AuthenticationFailedError{},
+		},
+		{
+			name:             "case 6: custom classifier",
+			failures:         2,
+			statusCode:       nethttp.StatusUnauthorized,
+			config:           Config{RetryMaxAttempts: 3, RetryIf: IsAuthenticationFailed},
+			expectedFailures: 2,
+		},
+		{
+			name:             "case 7: fetch retried",
+			fetch:            true,
+			failures:         2,
+			failPath:         "/git-upload-pack",
+			statusCode:       nethttp.StatusServiceUnavailable,
+			config:           Config{RetryMaxAttempts: 3},
+			expectedFailures: 2,
+		},
+		{
+			name:             "case 8: backoff canceled",
+			failures:         2,
+			statusCode:       nethttp.StatusServiceUnavailable,
+			config:           Config{RetryMaxAttempts: 3, RetryBackoff: time.Hour, RetryMaxBackoff: time.Hour},
+			timeout:          time.Second,
+			expectedFailures: 1,
+			expectedError:    &CanceledError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+			origin.branch("master", c0)
+
+			var failures atomic.Int64
+			served := newTestHTTPServer(t, origin.dir, func(w nethttp.ResponseWriter, r *nethttp.Request) bool {
+				if !strings.HasSuffix(r.URL.Path, tc.failPath) || failures.Load() >= int64(tc.failures) {
+					return false
+				}
+
+				failures.Add(1)
+				w.WriteHeader(tc.statusCode)
+				return true
+			})
+
+			c := tc.config
+			c.Dir = filepath.Join(t.TempDir(), "clone")
+			c.URL = served
+			if c.RetryBackoff == 0 {
+				c.RetryBackoff = time.Millisecond
+			}
+			if tc.existingDir {
+				err := os.Mkdir(c.Dir, 0755)
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+			}
+
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			expectedSHA := c0
+			if tc.fetch {
+				// Clone before the server starts failing.
+				failures.Store(int64(tc.failures))
+
+				err = repo.EnsureUpToDate(ctx)
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+
+				expectedSHA = origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1"}, "c1", t0.Add(time.Hour))
+				origin.branch("master", expectedSHA)
+
+				failures.Store(0)
+			}
+
+			if tc.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+
+			err = repo.EnsureUpToDate(ctx)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("err = %v, want %v", err, tc.expectedError)
+			}
+			if int(failures.Load()) != tc.expectedFailures {
+				t.Fatalf("failures = %d, want %d", failures.Load(), tc.expectedFailures)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+
+			sha, err := repo.storage.Reference(plumbing.NewRemoteReferenceName("origin", "master"))
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if sha.Hash() != expectedSHA {
+				t.Fatalf("sha = %s, want %s", sha.Hash(), expectedSHA)
+			}
+		})
+	}
+}
+
+func Test_New_retry(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		config        Config
+		expectedError error
+	}{
+		{
+			name:   "case 0: valid retry policy",
+			config: Config{RetryMaxAttempts: 3, RetryBackoff: time.Second, RetryMaxBackoff: time.Minute, RetryJitter: 0.5},
+		},
+		{
+			name:          "case 1: negative attempts",
+			config:        Config{RetryMaxAttempts: -1},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 2: max backoff lower than backoff",
+			config:        Config{RetryBackoff: time.Minute, RetryMaxBackoff: time.Second},
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 3: jitter too high",
+			config:        Config{RetryJitter: 1},
+			expectedError: &InvalidConfigError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			c := tc.config
+			c.InMemory = true
+			c.URL = "https://example.com/origin.git"
+
+			_, err := New(c)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("err = %v, want %v", err, tc.expectedError)
+			}
+		})
+	}
+}
+
+// newTestHTTPServer serves the repository in dir over the git smart HTTP
+// protocol using git http-backend and returns its URL. Requests for which
+// fail returns true are answered by fail only.
+func newTestHTTPServer(t *testing.T, dir string, fail func(w nethttp.ResponseWriter, r *nethttp.Request) bool) string {
+	t.Helper()
+
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skipf("git not found: %v", err)
+	}
+
+	backend := &cgi.Handler{
+		Path: git,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(dir),
+			"GIT_HTTP_EXPORT_ALL=1",
+		},
+	}
+
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if fail(w, r) {
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/" + filepath.Base(dir)
+}
@@ -0,0 +1,113 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// withDeepening runs the history walk f. When f fails because of a commit
+// missing in a shallow clone, the history is deepened and f is run again. The
+// depth of each deepening is twice the number of commits stored locally, so it
+// always reaches past the current shallow boundary. It returns
+// ShallowHistoryError when deepening does not fetch any new commits.
+func (r *Repo) withDeepening(ctx context.Context, repo *git.Repository, f func() error) error {
+	for {
+		err := f()
+		if !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+
+		shallows, serr := repo.Storer.Shallow()
+		if serr != nil {
+			return serr
+		}
+		if len(shallows) == 0 {
+			return err
+		}
+
+		before, err := countCommits(ctx, repo)
+		if err != nil {
+			return err
+		}
+
+		depth := 2 * before
+		if depth < r.cloneDepth {
+			depth = r.cloneDepth
+		}
+
+		unlock, err := r.acquireLock(ctx)
+		if err != nil {
+			return err
+		}
+		err = r.deepen(ctx, repo, depth)
+		unlock()
+		if err != nil {
+			return err
+		}
+
+		after, err := countCommits(ctx, repo)
+		if err != nil {
+			return err
+		}
+
+		if after == before {
+			return &ShallowHistoryError{message: fmt.Sprintf("history of %#q is missing commits after deepening to depth %d", r.redactedURL(), depth)}
+		}
+	}
+}
+
+// deepen fetches the history of all the fetched remotes to the depth.
+// Deepening is not meant to update anything so nothing is pruned.
+func (r *Repo) deepen(ctx context.Context, repo *git.Repository, depth int) error {
+	for _, remote := range r.fetchedRemotes() {
+		// Deepen the branches even when fetching only tags as the
+		// version walk usually starts from them.
+		branchRefSpecs, err := r.branchRefSpecs(repo, remote.name)
+		if err != nil {
+			return err
+		}
+
+		err = r.fetch(ctx, repo, remote, &git.FetchOptions{
+			RemoteName: remote.name,
+			RefSpecs:   append(branchRefSpecs, tagsRefSpec),
+			Auth:       remote.auth,
+			Depth:      depth,
+			Force:      true,
+			Progress:   r.progress,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// countCommits returns the number of commits stored in the repository.
+func countCommits(ctx context.Context, repo *git.Repository) (int, error) {
+	iter, err := repo.Storer.IterEncodedObjects(plumbing.CommitObject)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	var n int
+	err = iter.ForEach(func(plumbing.EncodedObject) error {
+		err := checkContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
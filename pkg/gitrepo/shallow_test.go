@@ -0,0 +1,180 @@
+package gitrepo
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Test_Repo_EnsureUpToDate_cloneOptions tests that depth, single branch and
+// tags only options limit what is cloned and fetched.
+func Test_Repo_EnsureUpToDate_cloneOptions(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name            string
+		config          Config
+		expectedRefs    []string
+		expectedMissing []string
+	}{
+		{
+			name:         "case 0: full clone",
+			expectedRefs: []string{"refs/remotes/origin/master", "refs/remotes/origin/other", "refs/tags/v1.0.0", "refs/tags/v1.1.0"},
+		},
+		{
+			name:            "case 1: single branch",
+			config:          Config{SingleBranch: "master"},
+			expectedRefs:    []string{"refs/remotes/origin/master", "refs/tags/v1.0.0", "refs/tags/v1.1.0"},
+			expectedMissing: []string{"refs/remotes/origin/other"},
+		},
+		{
+			name:            "case 2: tags only",
+			config:          Config{FetchTagsOnly: true},
+			expectedRefs:    []string{"refs/tags/v1.0.0", "refs/tags/v1.1.0"},
+			expectedMissing: []string{"refs/remotes/origin/other"},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, nil, "c0", t0)
+			c1 := origin.commit([]plumbing.Hash{c0}, nil, "c1", t0.Add(time.Hour))
+			origin.tag("v1.0.0", c0)
+			origin.branch("master", c1)
+
+			repo := origin.clone(tc.config)
+
+			// Move the remote after the initial clone.
+			c2 := origin.commit([]plumbing.Hash{c1}, nil, "c2", t0.Add(2*time.Hour))
+			o1 := origin.commit([]plumbing.Hash{c0}, nil, "o1", t0.Add(3*time.Hour))
+			origin.tag("v1.1.0", c2)
+			origin.branch("other", o1)
+			origin.branch("master", c2)
+
+			err := repo.EnsureUpToDate(context.Background())
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			for _, name := range tc.expectedRefs {
+				_, err := repo.storage.Reference(plumbing.ReferenceName(name))
+				if err != nil {
+					t.Fatalf("ref %q: err = %v, want %v", name, err, nil)
+				}
+			}
+			for _, name := range tc.expectedMissing {
+				_, err := repo.storage.Reference(plumbing.ReferenceName(name))
+				if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+					t.Fatalf("ref %q: err = %v, want %v", name, err, plumbing.ErrReferenceNotFound)
+				}
+			}
+
+			// Tags only fetch does not update branches.
+			master, err := repo.storage.Reference("refs/remotes/origin/master")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			expected := c2
+			if tc.config.FetchTagsOnly {
+				expected = c1
+			}
+			if master.Hash() != expected {
+				t.Fatalf("origin/master = %v, want %v", master.Hash(), expected)
+			}
+		})
+	}
+}
+
+// Test_Repo_ResolveVersion_shallow tests that ResolveVersion and NextVersion
+// deepen shallow clones until the version tag is found.
+func Test_Repo_ResolveVersion_shallow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	origin := newTestOrigin(t)
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	hashes := []plumbing.Hash{origin.commit(nil, nil, "feat: c0", t0)}
+	for i := 1; i < 10; i++ {
+		hashes = append(hashes, origin.commit(hashes[i-1:i], nil, "fix: c"+strconv.Itoa(i), t0.Add(time.Duration(i)*time.Hour)))
+	}
+	head := hashes[len(hashes)-1]
+	origin.tag("v1.0.0", hashes[1])
+	origin.branch("master", head)
+
+	repo := origin.clone(Config{CloneDepth: 1})
+
+	// The clone is shallow so the parent of HEAD is missing.
+	_, err := repo.storage.EncodedObject(plumbing.CommitObject, hashes[len(hashes)-2])
+	if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		t.Fatalf("err = %v, want %v", err, plumbing.ErrObjectNotFound)
+	}
+
+	version, err := repo.ResolveVersion(ctx, "master")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	if expected := "1.0.0-" + head.String(); version != expected {
+		t.Fatalf("version = %q, want %q", version, expected)
+	}
+
+	result, err := repo.NextVersion(ctx, "master")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	if result.Version != "1.0.1" {
+		t.Fatalf("version = %q, want %q", result.Version, "1.0.1")
+	}
+	if len(result.Commits) != 8 {
+		t.Fatalf("len(commits) = %d, want %d", len(result.Commits), 8)
+	}
+}
+
+// Test_Repo_ResolveVersion_shallowOrigin tests that ResolveVersion returns
+// ShallowHistoryError when the history cannot be deepened because the origin
+// itself is shallow.
+func Test_Repo_ResolveVersion_shallowOrigin(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	upstream := newTestOrigin(t)
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c0 := upstream.commit(nil, nil, "c0", t0)
+	c1 := upstream.commit([]plumbing.Hash{c0}, nil, "c1", t0.Add(time.Hour))
+	c2 := upstream.commit([]plumbing.Hash{c1}, nil, "c2", t0.Add(2*time.Hour))
+	c3 := upstream.commit([]plumbing.Hash{c2}, nil, "c3", t0.Add(3*time.Hour))
+	upstream.branch("master", c3)
+
+	originDir := filepath.Join(t.TempDir(), "origin")
+	_, err := git.PlainClone(originDir, false, &git.CloneOptions{URL: upstream.dir, Depth: 2})
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	repo, err := New(Config{Dir: filepath.Join(t.TempDir(), "clone"), URL: originDir, CloneDepth: 1})
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	err = repo.EnsureUpToDate(ctx)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	_, err = repo.ResolveVersion(ctx, "master")
+	if !errors.Is(err, &ShallowHistoryError{}) {
+		t.Fatalf("err = %v, want %v", err, &ShallowHistoryError{})
+	}
+}
@@ -0,0 +1,99 @@
+package gitrepo
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var tagPrefixEnvVarName = "GS_GIT_TAG_PREFIX"
+
+// defaultVersionTagPattern matches tags in format "vX.Y.Z". Anything after the
+// patch number, e.g. pre-release or build metadata, is part of the version.
+var defaultVersionTagPattern = regexp.MustCompile(`^v(?P<version>[0-9]+\.[0-9]+\.[0-9]+.*)$`)
+
+// modulePrefixRegex matches tag prefixes of monorepo modules, i.e. the part
+// before "/" in tags like "module-a/v1.2.3".
+var modulePrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+
+const versionSubexpName = "version"
+
+func compileVersionTagPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return defaultVersionTagPattern, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("failed to compile version tag pattern %#q with error %#q", pattern, err)}
+	}
+	if re.SubexpIndex(versionSubexpName) < 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("version tag pattern %#q must have a %#q named group", pattern, versionSubexpName)}
+	}
+
+	return re, nil
+}
+
+// tagMatcher selects tags belonging to a module and extracts versions out of
+// version tags.
+type tagMatcher struct {
+	// prefix is the module tag prefix. Tags of the module are in format
+	// "<prefix>/<tag>". When empty, tags in format "<module>/<tag>" where
+	// <tag> is a version tag belong to other modules and are ignored.
+	prefix string
+	// pattern matches version tags with the prefix trimmed.
+	pattern *regexp.Regexp
+}
+
+// tagMatcher returns tagMatcher for the configured tag prefix and version tag
+// pattern. When the tag prefix is not configured the GS_GIT_TAG_PREFIX
+// environment variable is used as a fallback.
+func (r *Repo) tagMatcher() *tagMatcher {
+	prefix := r.tagPrefix
+	if prefix == "" {
+		prefix = os.Getenv(tagPrefixEnvVarName)
+	}
+
+	m := &tagMatcher{
+		prefix:  prefix,
+		pattern: r.versionTagPattern,
+	}
+
+	return m
+}
+
+// owns returns true if the tag belongs to the module.
+func (m *tagMatcher) owns(tag string) bool {
+	if m.prefix != "" {
+		return strings.HasPrefix(tag, m.prefix+"/")
+	}
+
+	prefix, rest, ok := strings.Cut(tag, "/")
+	if !ok {
+		return true
+	}
+
+	return !modulePrefixRegex.MatchString(prefix) || !m.pattern.MatchString(rest)
+}
+
+// version returns the version of the tag if it is a version tag of the
+// module, e.g. "1.2.3" for "v1.2.3" or "module-a/v1.2.3" when the prefix is
+// "module-a".
+func (m *tagMatcher) version(tag string) (string, bool) {
+	if !m.owns(tag) {
+		return "", false
+	}
+
+	rest := tag
+	if m.prefix != "" {
+		rest = strings.TrimPrefix(tag, m.prefix+"/")
+	}
+
+	match := m.pattern.FindStringSubmatch(rest)
+	if match == nil {
+		return "", false
+	}
+
+	return match[m.pattern.SubexpIndex(versionSubexpName)], true
+}
@@ -0,0 +1,163 @@
+package gitrepo
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func Test_tagMatcher(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		prefix          string
+		pattern         *regexp.Regexp
+		tag             string
+		expectedOwns    bool
+		expectedVersion string
+		expectedOK      bool
+	}{
+		{
+			name:            "case 0: version tag",
+			pattern:         defaultVersionTagPattern,
+			tag:             "v1.2.3",
+			expectedOwns:    true,
+			expectedVersion: "1.2.3",
+			expectedOK:      true,
+		},
+		{
+			name:            "case 1: pre-release version tag",
+			pattern:         defaultVersionTagPattern,
+			tag:             "v1.2.3-rc.1",
+			expectedOwns:    true,
+			expectedVersion: "1.2.3-rc.1",
+			expectedOK:      true,
+		},
+		{
+			name:         "case 2: non-version tag",
+			pattern:      defaultVersionTagPattern,
+			tag:          "test-tag",
+			expectedOwns: true,
+		},
+		{
+			name:    "case 3: version tag of other module",
+			pattern: defaultVersionTagPattern,
+			tag:     "module-a/v1.2.3",
+		},
+		{
+			name:         "case 4: non-version tag with slash",
+			pattern:      defaultVersionTagPattern,
+			tag:          "feature/test",
+			expectedOwns: true,
+		},
+		{
+			name:            "case 5: version tag of the module",
+			prefix:          "module-a",
+			pattern:         defaultVersionTagPattern,
+			tag:             "module-a/v1.2.3",
+			expectedOwns:    true,
+			expectedVersion: "1.2.3",
+			expectedOK:      true,
+		},
+		{
+			name:    "case 6: version tag without prefix",
+			prefix:  "module-a",
+			pattern: defaultVersionTagPattern,
+			tag:     "v1.2.3",
+		},
+		{
+			name:    "case 7: version tag of other module with prefix",
+			prefix:  "module-a",
+			pattern: defaultVersionTagPattern,
+			tag:     "module-b/v1.2.3",
+		},
+		{
+			name:            "case 8: custom pattern",
+			pattern:         regexp.MustCompile(`^release-(?P<version>\d+\.\d+\.\d+)$`),
+			tag:             "release-1.2.3",
+			expectedOwns:    true,
+			expectedVersion: "1.2.3",
+			expectedOK:      true,
+		},
+		{
+			name:         "case 9: custom pattern not matching",
+			pattern:      regexp.MustCompile(`^release-(?P<version>\d+\.\d+\.\d+)$`),
+			tag:          "v1.2.3",
+			expectedOwns: true,
+		},
+		{
+			name:            "case 10: custom pattern with prefix",
+			prefix:          "charts",
+			pattern:         regexp.MustCompile(`^chart-name-(?P<version>.+)$`),
+			tag:             "charts/chart-name-1.2.3",
+			expectedOwns:    true,
+			expectedVersion: "1.2.3",
+			expectedOK:      true,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			m := &tagMatcher{
+				prefix:  tc.prefix,
+				pattern: tc.pattern,
+			}
+
+			owns := m.owns(tc.tag)
+			if owns != tc.expectedOwns {
+				t.Fatalf("owns = %v, want %v", owns, tc.expectedOwns)
+			}
+
+			version, ok := m.version(tc.tag)
+			if ok != tc.expectedOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.expectedOK)
+			}
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
+
+func Test_compileVersionTagPattern(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		pattern       string
+		expectedError error
+	}{
+		{
+			name: "case 0: default",
+		},
+		{
+			name:    "case 1: valid pattern",
+			pattern: `^release-(?P<version>.+)$`,
+		},
+		{
+			name:          "case 2: invalid pattern",
+			pattern:       `^release-(?P<version>.+$`,
+			expectedError: &InvalidConfigError{},
+		},
+		{
+			name:          "case 3: pattern without version group",
+			pattern:       `^release-(.+)$`,
+			expectedError: &InvalidConfigError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			_, err := compileVersionTagPattern(tc.pattern)
+			if reflect.TypeOf(err) != reflect.TypeOf(tc.expectedError) {
+				t.Fatalf("err = %#v, want %#v", err, tc.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// CreateTagOptions configures Repo.CreateTag.
+type CreateTagOptions struct {
+	// Message is the message of an annotated tag. When empty a lightweight
+	// tag is created.
+	Message string
+	// Tagger is the identity of the annotated tag creator. It is required
+	// when Message is set. When its time is zero the current time is used.
+	Tagger *object.Signature
+	// Force replaces an existing tag with the same name.
+	Force bool
+}
+
+// PushTagsOptions configures Repo.PushTagsWithOptions.
+type PushTagsOptions struct {
+	// Force overwrites tags which already exist in the remote and point
+	// to a different object.
+	Force bool
+}
+
+// CreateTag creates a tag with the name pointing at the commit the ref
+// resolves to and returns the full tag name.
+//
+// If Config.TagPrefix, or GS_GIT_TAG_PREFIX environment variable as a
+// fallback, is set and the name is not already prefixed, the tag is created as
+// "<prefix>/<name>", e.g. "module-a/v1.2.3" for "v1.2.3".
+//
+// It returns error matching TagAlreadyExistsError if the tag already exists
+// and opts.Force is not set.
+func (r *Repo) CreateTag(ctx context.Context, ref, name string, opts CreateTagOptions) (string, error) {
+	err := checkContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name = r.tagName(name)
+
+	if opts.Message != "" && opts.Tagger == nil {
+		return "", &ExecutionFailedError{message: fmt.Sprintf("tagger must be set for annotated tag %#q", name)}
+	}
+
+	repo, err := r.open()
+	if err != nil {
+		return "", err
+	}
+
+	commit, err := r.resolveCommit(repo, ref)
+	if err != nil {
+		return "", err
+	}
+
+	var tagOpts *git.CreateTagOptions
+	if opts.Message != "" {
+		tagger := *opts.Tagger
+		if tagger.When.IsZero() {
+			tagger.When = time.Now()
+		}
+
+		tagOpts = &git.CreateTagOptions{
+			Tagger:  &tagger,
+			Message: opts.Message,
+		}
+	}
+
+	if opts.Force {
+		err = repo.DeleteTag(name)
+		if errors.Is(err, git.ErrTagNotFound) {
+			// Fall through.
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	_, err = repo.CreateTag(name, commit.Hash, tagOpts)
+	if errors.Is(err, git.ErrTagExists) {
+		return "", &TagAlreadyExistsError{message: fmt.Sprintf("%#q", name)}
+	} else if errors.Is(err, plumbing.ErrInvalidReferenceName) {
+		return "", &ExecutionFailedError{message: fmt.Sprintf("tag name %#q is not a valid reference name", name)}
+	} else if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// PushTags pushes the tags with the names to the remote using the same
+// authentication as EnsureUpToDate. Names are prefixed the same way as in
+// CreateTag.
+//
+// Tags already present in the remote and pointing to the same object are
+// skipped. It returns error matching TagAlreadyExistsError if any of the tags
+// exists in the remote and points to a different object. Use
+// PushTagsWithOptions to overwrite them.
+func (r *Repo) PushTags(ctx context.Context, names ...string) error {
+	return r.PushTagsWithOptions(ctx, PushTagsOptions{}, names...)
+}
+
+// PushTagsWithOptions is PushTags with options.
+func (r *Repo) PushTagsWithOptions(ctx context.Context, opts PushTagsOptions, names ...string) error {
+	err := checkContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	repo, err := r.open()
+	if err != nil {
+		return err
+	}
+
+	// Use the configured URL rather than the one stored in the
+	// repository config, the same as clone in EnsureUpToDate.
+	remote := git.NewRemote(r.storage, &config.RemoteConfig{
+		Name: r.remoteName,
+		URLs: []string{r.url},
+	})
+
+	remoteRefs := map[plumbing.ReferenceName]plumbing.Hash{}
+	{
+		refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: r.auth})
+		err = contextError(ctx, "list remote", err)
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			// Fall through.
+		} else if err != nil {
+			return transportError(r.primaryRemote(), err)
+		}
+
+		for _, ref := range refs {
+			remoteRefs[ref.Name()] = ref.Hash()
+		}
+	}
+
+	var refSpecs []config.RefSpec
+	for _, name := range names {
+		name = r.tagName(name)
+		refName := plumbing.NewTagReferenceName(name)
+
+		ref, err := repo.Reference(refName, false)
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return &ReferenceNotFoundError{Ref: name, message: fmt.Sprintf("tag %#q", name)}
+		} else if err != nil {
+			return err
+		}
+
+		remoteHash, ok := remoteRefs[refName]
+		if ok && remoteHash == ref.Hash() {
+			continue
+		}
+		if ok && !opts.Force {
+			return &TagAlreadyExistsError{message: fmt.Sprintf("tag %#q exists in remote %#q and points to %#q", name, r.redactedURL(), remoteHash)}
+		}
+
+		spec := refName.String() + ":" + refName.String()
+		if opts.Force {
+			spec = "+" + spec
+		}
+
+		refSpecs = append(refSpecs, config.RefSpec(spec))
+	}
+
+	if len(refSpecs) == 0 {
+		return nil
+	}
+
+	err = remote.PushContext(ctx, &git.PushOptions{
+		RemoteName: r.remoteName,
+		RefSpecs:   refSpecs,
+		Auth:       r.auth,
+	})
+	err = contextError(ctx, "push", err)
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		// Fall through.
+	} else if err != nil {
+		return transportError(r.primaryRemote(), err)
+	}
+
+	return nil
+}
+
+// tagName prefixes the tag name with the module tag prefix unless it is
+// already prefixed.
+func (r *Repo) tagName(name string) string {
+	prefix := r.tagMatcher().prefix
+	if prefix == "" || strings.HasPrefix(name, prefix+"/") {
+		return name
+	}
+
+	return prefix + "/" + name
+}
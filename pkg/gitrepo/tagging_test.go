@@ -0,0 +1,224 @@
+package gitrepo
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Test_Repo_CreateTag tests creating lightweight and annotated tags in
+// a local clone.
+func Test_Repo_CreateTag(t *testing.T) {
+	t.Parallel()
+
+	tagger := &object.Signature{
+		Name:  "gitrepo-test",
+		Email: "gitrepo-test@example.com",
+		When:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	testCases := []struct {
+		name            string
+		config          Config
+		existingTag     string
+		tag             string
+		opts            CreateTagOptions
+		expectedTag     string
+		expectedVersion string
+		expectedMessage string
+		expectedError   error
+	}{
+		{
+			name:            "case 0: lightweight tag",
+			tag:             "v1.2.3",
+			expectedTag:     "v1.2.3",
+			expectedVersion: "1.2.3",
+		},
+		{
+			name:            "case 1: annotated tag",
+			tag:             "v1.2.3",
+			opts:            CreateTagOptions{Message: "Release v1.2.3", Tagger: tagger},
+			expectedTag:     "v1.2.3",
+			expectedVersion: "1.2.3",
+			expectedMessage: "Release v1.2.3\n",
+		},
+		{
+			name:            "case 2: tag prefix",
+			config:          Config{TagPrefix: "module-a"},
+			tag:             "v1.2.3",
+			expectedTag:     "module-a/v1.2.3",
+			expectedVersion: "1.2.3",
+		},
+		{
+			name:            "case 3: already prefixed",
+			config:          Config{TagPrefix: "module-a"},
+			tag:             "module-a/v1.2.3",
+			expectedTag:     "module-a/v1.2.3",
+			expectedVersion: "1.2.3",
+		},
+		{
+			name:          "case 4: existing tag",
+			existingTag:   "v1.2.3",
+			tag:           "v1.2.3",
+			expectedError: &TagAlreadyExistsError{},
+		},
+		{
+			name:            "case 5: existing tag forced",
+			existingTag:     "v1.2.3",
+			tag:             "v1.2.3",
+			opts:            CreateTagOptions{Message: "Release v1.2.3", Tagger: tagger, Force: true},
+			expectedTag:     "v1.2.3",
+			expectedVersion: "1.2.3",
+			expectedMessage: "Release v1.2.3\n",
+		},
+		{
+			name:          "case 6: annotated tag without tagger",
+			tag:           "v1.2.3",
+			opts:          CreateTagOptions{Message: "Release v1.2.3"},
+			expectedError: &ExecutionFailedError{},
+		},
+		{
+			name:          "case 7: invalid name",
+			tag:           "v1.2.3..",
+			expectedError: &ExecutionFailedError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, nil, "c0", time.Now().Add(-time.Hour))
+			c1 := origin.commit([]plumbing.Hash{c0}, nil, "c1", time.Now())
+			origin.branch("master", c1)
+			if tc.existingTag != "" {
+				origin.tag(tc.existingTag, c0)
+			}
+
+			repo := origin.clone(tc.config)
+
+			tag, err := repo.CreateTag(ctx, "master", tc.tag, tc.opts)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("err = %v, want %v", err, tc.expectedError)
+			}
+			if tc.expectedError != nil {
+				return
+			}
+
+			if tag != tc.expectedTag {
+				t.Fatalf("tag = %q, want %q", tag, tc.expectedTag)
+			}
+
+			version, err := repo.ResolveVersion(ctx, "master")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+
+			r, err := git.Open(repo.storage, repo.worktree)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			ref, err := r.Tag(tag)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			var message string
+			tagObject, err := r.TagObject(ref.Hash())
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				// Lightweight tag.
+			} else if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			} else {
+				message = tagObject.Message
+				if tagObject.Tagger.Email != tagger.Email {
+					t.Fatalf("tagger = %q, want %q", tagObject.Tagger.Email, tagger.Email)
+				}
+			}
+			if message != tc.expectedMessage {
+				t.Fatalf("message = %q, want %q", message, tc.expectedMessage)
+			}
+		})
+	}
+}
+
+// Test_Repo_PushTags tests pushing tags to a local origin including refusing
+// and forcing overwrites of existing remote tags.
+func Test_Repo_PushTags(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	origin := newTestOrigin(t)
+	c0 := origin.commit(nil, nil, "c0", time.Now().Add(-time.Hour))
+	c1 := origin.commit([]plumbing.Hash{c0}, nil, "c1", time.Now())
+	origin.branch("master", c1)
+	origin.tag("module-a/v1.0.0", c0)
+
+	repo := origin.clone(Config{TagPrefix: "module-a"})
+
+	_, err := repo.CreateTag(ctx, "master", "v1.1.0", CreateTagOptions{})
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	_, err = repo.CreateTag(ctx, "master", "v1.0.0", CreateTagOptions{Force: true})
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	// Push a new tag, the already present one is skipped.
+	err = repo.PushTags(ctx, "v1.1.0")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	err = repo.PushTags(ctx, "v1.1.0")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	assertTag(t, origin, "module-a/v1.1.0", c1)
+
+	// Refuse to overwrite the moved tag.
+	err = repo.PushTags(ctx, "v1.0.0")
+	if !errors.Is(err, &TagAlreadyExistsError{}) {
+		t.Fatalf("err = %v, want %v", err, &TagAlreadyExistsError{})
+	}
+	assertTag(t, origin, "module-a/v1.0.0", c0)
+
+	// Overwrite it when forced.
+	err = repo.PushTagsWithOptions(ctx, PushTagsOptions{Force: true}, "v1.0.0")
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	assertTag(t, origin, "module-a/v1.0.0", c1)
+
+	// Pushing unknown tag fails.
+	err = repo.PushTags(ctx, "v2.0.0")
+	if !errors.Is(err, &ReferenceNotFoundError{}) {
+		t.Fatalf("err = %v, want %v", err, &ReferenceNotFoundError{})
+	}
+}
+
+func assertTag(t *testing.T, origin *testOrigin, name string, expected plumbing.Hash) {
+	t.Helper()
+
+	ref, err := origin.repo.Tag(name)
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+	if ref.Hash() != expected {
+		t.Fatalf("tag %q = %v, want %v", name, ref.Hash(), expected)
+	}
+}
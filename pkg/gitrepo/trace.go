@@ -0,0 +1,62 @@
+package gitrepo
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Names of the spans started by Repo.
+const (
+	// SpanClone is started for each clone attempt. Its "exists" attribute
+	// is true when the repository was cloned already.
+	SpanClone = "gitrepo.clone"
+	// SpanFetch is started for each fetch of a remote, including fetches
+	// deepening shallow clones.
+	SpanFetch = "gitrepo.fetch"
+	// SpanListTags is started for enumeration of the tags.
+	SpanListTags = "gitrepo.list_tags"
+	// SpanVersionWalk is started for the history walk looking for the base
+	// version of ResolveVersion and NextVersion.
+	SpanVersionWalk = "gitrepo.version_walk"
+)
+
+// Tracer starts spans around repository operations. It is meant to be
+// adapted to a tracing library, e.g. OpenTelemetry. Attributes are slog
+// attributes so the package does not depend on any.
+type Tracer interface {
+	// Start starts the span with the name, e.g. SpanClone, as a child of
+	// the span carried by ctx. The returned context carries the started
+	// span.
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is an operation started by Tracer.
+type Span interface {
+	// SetAttributes adds attributes known after the span started.
+	SetAttributes(attrs ...slog.Attr)
+	// End ends the span. err is the error the operation failed with or
+	// nil.
+	End(err error)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...slog.Attr) {}
+
+func (noopSpan) End(err error) {}
+
+// startSpan starts the span with the URL of the repository.
+func (r *Repo) startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	return r.tracer.Start(ctx, name, append([]slog.Attr{slog.String("url", r.redactedURL())}, attrs...)...)
+}
+
+// redactedURL returns the URL of the repository without the password.
+func (r *Repo) redactedURL() string {
+	return redact(r.url, urlSecret(r.url))
+}
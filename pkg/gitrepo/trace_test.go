@@ -0,0 +1,178 @@
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Test_Repo_tracing tests that spans are started around the operations and
+// the version walk is logged.
+func Test_Repo_tracing(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name         string
+		strategy     VersionStrategy
+		expectedLogs []string
+	}{
+		{
+			name:         "case 0: most recent version",
+			expectedLogs: []string{"found version tags", "found base version"},
+		},
+		{
+			name:         "case 1: highest version",
+			strategy:     VersionStrategyHighest,
+			expectedLogs: []string{"found version tags", "found candidate version", "found base version"},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+			c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1"}, "c1", t0.Add(time.Hour))
+			origin.tag("v1.0.0", c0)
+			origin.branch("master", c1)
+
+			var logs bytes.Buffer
+			tracer := &testTracer{}
+			repo, err := New(Config{
+				Dir:             filepath.Join(t.TempDir(), "clone"),
+				URL:             origin.dir,
+				VersionStrategy: tc.strategy,
+				Logger:          slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+				Tracer:          tracer,
+			})
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			err = repo.EnsureUpToDate(ctx)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			_, err = repo.ResolveVersion(ctx, "master")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			var names []string
+			for _, s := range tracer.spans {
+				if !s.ended || s.err != nil {
+					t.Fatalf("span %q: ended = %v, err = %v, want ended without error", s.name, s.ended, s.err)
+				}
+				if s.attr("url") != origin.dir {
+					t.Fatalf("span %q: url = %q, want %q", s.name, s.attr("url"), origin.dir)
+				}
+				names = append(names, s.name)
+			}
+			expectedNames := []string{SpanClone, SpanFetch, SpanListTags, SpanVersionWalk}
+			if diff := cmp.Diff(expectedNames, names); diff != "" {
+				t.Fatalf("span names mismatch (-want +got):\n%s", diff)
+			}
+
+			walk := tracer.spans[3]
+			if walk.attr("version") != "1.0.0" || walk.attr("version_commit") != c0.String() {
+				t.Fatalf("version = %q at %q, want %q at %q", walk.attr("version"), walk.attr("version_commit"), "1.0.0", c0)
+			}
+
+			for _, msg := range tc.expectedLogs {
+				if !strings.Contains(logs.String(), "msg=\""+msg+"\"") {
+					t.Fatalf("logs = %q, want message %q", logs.String(), msg)
+				}
+			}
+			if !strings.Contains(logs.String(), "tags=[v1.0.0]") || !strings.Contains(logs.String(), "version_commit="+c0.String()) {
+				t.Fatalf("logs = %q, want candidate tags and base version commit", logs.String())
+			}
+		})
+	}
+}
+
+// Test_Repo_tracing_error tests that spans of failed operations end with the
+// error.
+func Test_Repo_tracing_error(t *testing.T) {
+	t.Parallel()
+
+	tracer := &testTracer{}
+	repo, err := New(Config{
+		Dir:    filepath.Join(t.TempDir(), "clone"),
+		URL:    filepath.Join(t.TempDir(), "does-not-exist"),
+		Tracer: tracer,
+	})
+	if err != nil {
+		t.Fatalf("err = %v, want %v", err, nil)
+	}
+
+	err = repo.EnsureUpToDate(context.Background())
+	if !errors.Is(err, &RepositoryNotFoundError{}) {
+		t.Fatalf("err = %v, want %v", err, &RepositoryNotFoundError{})
+	}
+
+	if len(tracer.spans) != 1 || tracer.spans[0].name != SpanClone {
+		t.Fatalf("spans = %v, want one %q span", tracer.spans, SpanClone)
+	}
+	if !errors.Is(tracer.spans[0].err, &RepositoryNotFoundError{}) {
+		t.Fatalf("err = %v, want %v", tracer.spans[0].err, &RepositoryNotFoundError{})
+	}
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &testSpan{name: name, attrs: attrs}
+	t.spans = append(t.spans, s)
+
+	return ctx, s
+}
+
+type testSpan struct {
+	name  string
+	attrs []slog.Attr
+	ended bool
+	err   error
+}
+
+func (s *testSpan) SetAttributes(attrs ...slog.Attr) {
+	s.attrs = append(s.attrs, attrs...)
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+// attr returns the last value of the attribute with the key.
+func (s *testSpan) attr(key string) string {
+	var v string
+	for _, a := range s.attrs {
+		if a.Key == key {
+			v = a.Value.String()
+		}
+	}
+
+	return v
+}
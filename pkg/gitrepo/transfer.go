@@ -0,0 +1,144 @@
+package gitrepo
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// packfileHeaderSize is the size of the packfile signature, version and
+// number of objects.
+const packfileHeaderSize = 12
+
+// TransferStats describes data received by the clone and fetches done by
+// EnsureUpToDateWithReport. Retried attempts are included.
+type TransferStats struct {
+	// Fetched is true when any objects were received.
+	Fetched bool
+	// Objects is the number of objects received.
+	Objects int
+	// Bytes is the size of the packfiles received.
+	Bytes int64
+	// Duration is the time spent cloning and fetching.
+	Duration time.Duration
+}
+
+// transferStorer wraps the storage of the repository during a clone or fetch
+// to count the received packfiles. The packfiles are stored the same way
+// go-git stores them in the wrapped storage.
+type transferStorer struct {
+	storage.Storer
+	stats *TransferStats
+}
+
+// fsTransferStorer is transferStorer of a storage based on a filesystem.
+// go-git uses the filesystem to set up the worktree on clone.
+type fsTransferStorer struct {
+	*transferStorer
+	fs billy.Filesystem
+}
+
+func newTransferStorer(s storage.Storer, stats *TransferStats) storage.Storer {
+	ts := &transferStorer{Storer: s, stats: stats}
+	if fsBased, ok := s.(interface{ Filesystem() billy.Filesystem }); ok {
+		return &fsTransferStorer{transferStorer: ts, fs: fsBased.Filesystem()}
+	}
+
+	return ts
+}
+
+// Init implements storer.Initializer.
+func (s *transferStorer) Init() error {
+	i, ok := s.Storer.(storer.Initializer)
+	if !ok {
+		return nil
+	}
+
+	return i.Init()
+}
+
+// PackfileWriter implements storer.PackfileWriter. Packfiles for storages
+// which cannot write them are parsed into objects instead.
+func (s *transferStorer) PackfileWriter() (io.WriteCloser, error) {
+	var w io.WriteCloser
+	if pw, ok := s.Storer.(storer.PackfileWriter); ok {
+		var err error
+		w, err = pw.PackfileWriter()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		w = newParsingWriter(s.Storer)
+	}
+
+	return &countingWriter{w: w, stats: s.stats}, nil
+}
+
+func (s *fsTransferStorer) Filesystem() billy.Filesystem {
+	return s.fs
+}
+
+// countingWriter counts bytes of the packfile written to it and reads the
+// number of objects from its header.
+type countingWriter struct {
+	w      io.WriteCloser
+	stats  *TransferStats
+	header []byte
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.stats.Bytes += int64(n)
+
+	if len(w.header) < packfileHeaderSize {
+		w.header = append(w.header, p[:min(n, packfileHeaderSize-len(w.header))]...)
+		if len(w.header) == packfileHeaderSize {
+			w.stats.Objects += int(binary.BigEndian.Uint32(w.header[8:]))
+		}
+	}
+
+	return n, err
+}
+
+func (w *countingWriter) Close() error {
+	return w.w.Close()
+}
+
+// parsingWriter stores objects of the packfile written to it in the storage.
+// Close returns once all the objects are stored.
+type parsingWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newParsingWriter(s storer.Storer) *parsingWriter {
+	pr, pw := io.Pipe()
+	w := &parsingWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+
+	go func() {
+		err := packfile.UpdateObjectStorage(s, pr)
+		// Unblock writes when parsing stops early.
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w
+}
+
+func (w *parsingWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *parsingWriter) Close() error {
+	_ = w.pw.Close()
+
+	return <-w.done
+}
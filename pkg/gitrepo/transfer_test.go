@@ -0,0 +1,97 @@
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Test_Repo_EnsureUpToDate_transfer tests that received objects and bytes are
+// reported and progress of the remote is written to Config.Progress.
+func Test_Repo_EnsureUpToDate_transfer(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "case 0: directory",
+			config: Config{Dir: filepath.Join(t.TempDir(), "clone")},
+		},
+		{
+			name:   "case 1: in memory",
+			config: Config{InMemory: true},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+			t.Parallel()
+
+			ctx := context.Background()
+
+			origin := newTestOrigin(t)
+			c0 := origin.commit(nil, map[string]string{"file": "c0"}, "c0", t0)
+			origin.tag("v1.0.0", c0)
+			origin.branch("master", c0)
+
+			var progress bytes.Buffer
+			c := tc.config
+			c.URL = origin.dir
+			c.Progress = &progress
+
+			repo, err := New(c)
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+
+			// Commit, tree and blob are received by each update.
+			for j, expectedObjects := range []int{3, 0, 3} {
+				if j == 2 {
+					c1 := origin.commit([]plumbing.Hash{c0}, map[string]string{"file": "c1"}, "c1", t0.Add(time.Hour))
+					origin.branch("master", c1)
+				}
+
+				report, err := repo.EnsureUpToDateWithReport(ctx)
+				if err != nil {
+					t.Fatalf("err = %v, want %v", err, nil)
+				}
+
+				transfer := report.Transfer
+				if transfer.Objects != expectedObjects {
+					t.Fatalf("update %d: objects = %d, want %d", j, transfer.Objects, expectedObjects)
+				}
+				if transfer.Fetched != (expectedObjects > 0) {
+					t.Fatalf("update %d: fetched = %v, want %v", j, transfer.Fetched, expectedObjects > 0)
+				}
+				if (transfer.Bytes > 0) != (expectedObjects > 0) {
+					t.Fatalf("update %d: bytes = %d, want bytes received %v", j, transfer.Bytes, expectedObjects > 0)
+				}
+				if transfer.Duration <= 0 {
+					t.Fatalf("update %d: duration = %v, want positive", j, transfer.Duration)
+				}
+			}
+
+			if progress.Len() == 0 {
+				t.Fatalf("progress is empty, want messages of the remote")
+			}
+
+			content, err := repo.GetFileContent("file", "master")
+			if err != nil {
+				t.Fatalf("err = %v, want %v", err, nil)
+			}
+			if string(content) != "c1" {
+				t.Fatalf("content = %q, want %q", content, "c1")
+			}
+		})
+	}
+}
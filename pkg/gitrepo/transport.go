@@ -0,0 +1,103 @@
+package gitrepo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"syscall"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// transportError converts err returned by a clone, fetch, list or push of the
+// remote to RepositoryNotFoundError, AuthenticationFailedError,
+// AuthorizationFailedError or NetworkError. Other errors, including
+// CanceledError, are returned as they are. Secrets of the remote are redacted
+// from the URL and the message.
+func transportError(remote repoRemote, err error) error {
+	if err == nil || errors.Is(err, &CanceledError{}) {
+		return err
+	}
+
+	secrets := []string{urlSecret(remote.url)}
+	if auth, ok := remote.auth.(*http.BasicAuth); ok {
+		secrets = append(secrets, auth.Password)
+	}
+
+	u := redact(remote.url, secrets...)
+	message := func() string {
+		return redact(fmt.Sprintf("%#q: %s", remote.url, err), secrets...)
+	}
+
+	if errors.Is(err, transport.ErrRepositoryNotFound) {
+		return &RepositoryNotFoundError{URL: u, message: redact(fmt.Sprintf("%#q", remote.url), secrets...)}
+	}
+	if errors.Is(err, transport.ErrAuthenticationRequired) || isSSHAuthenticationError(err) || isCertificateError(err) {
+		return &AuthenticationFailedError{URL: u, message: message(), cause: err}
+	}
+	if errors.Is(err, transport.ErrAuthorizationFailed) {
+		return &AuthorizationFailedError{URL: u, message: message(), cause: err}
+	}
+
+	var statusCode int
+	var unexpectedErr *plumbing.UnexpectedError
+	if errors.As(err, &unexpectedErr) {
+		var httpErr *http.Err
+		if errors.As(unexpectedErr.Err, &httpErr) {
+			statusCode = httpErr.StatusCode()
+		}
+	}
+	if statusCode >= nethttp.StatusInternalServerError || statusCode == nethttp.StatusTooManyRequests || statusCode == nethttp.StatusRequestTimeout {
+		return &NetworkError{URL: u, StatusCode: statusCode, message: message(), cause: err}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return &NetworkError{URL: u, Timeout: netErr.Timeout(), message: message(), cause: err}
+	}
+	// The connection closed in the middle of a response ends with
+	// unexpected EOF.
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &NetworkError{URL: u, message: message(), cause: err}
+	}
+
+	return err
+}
+
+// isCertificateError returns true when the TLS certificate of the remote
+// could not be verified. It must be checked before net.Error as the error is
+// wrapped in url.Error implementing it.
+func isCertificateError(err error) bool {
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	return errors.As(err, &verificationErr) || errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+// isSSHAuthenticationError returns true when the SSH handshake failed because
+// the client or the server could not be authenticated. The SSH package does
+// not export errors for these so messages are matched.
+func isSSHAuthenticationError(err error) bool {
+	s := err.Error()
+	for _, m := range []string{
+		"ssh: unable to authenticate",
+		"knownhosts: key mismatch",
+		"knownhosts: key is unknown",
+		"is not pinned",
+	} {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+
+	return false
+}
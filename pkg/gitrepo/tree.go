@@ -0,0 +1,140 @@
+package gitrepo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// resolveCommit resolves ref to a commit. It returns error handled by
+// IsReferenceNotFound if the ref does not exist.
+func (r *Repo) resolveCommit(repo *git.Repository, ref string) (*object.Commit, error) {
+	hash, err := r.resolveRevision(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit, nil
+}
+
+// resolveTree resolves ref to the root tree of the commit it points to. When
+// empty ref defaults to master branch.
+func (r *Repo) resolveTree(repo *git.Repository, ref string) (*object.Commit, *object.Tree, error) {
+	if ref == "" {
+		ref = plumbing.Master.Short()
+	}
+
+	commit, err := r.resolveCommit(repo, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return commit, tree, nil
+}
+
+// treePath converts path relative to the repository root to a path in git
+// tree. It returns empty string for the root tree.
+func treePath(p string) string {
+	p = path.Clean("/" + p)
+	return strings.TrimPrefix(p, "/")
+}
+
+// readTreeFile reads content of the file stored at path in the tree.
+func readTreeFile(tree *object.Tree, p string) ([]byte, error) {
+	entry, err := tree.FindEntry(treePath(p))
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, &FileNotFoundError{Path: p, message: fmt.Sprintf("%#q", p)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !entry.Mode.IsFile() {
+		return nil, &FileNotFoundError{Path: p, message: fmt.Sprintf("%#q is not a file", p)}
+	}
+
+	file, err := tree.TreeEntryFile(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(content), nil
+}
+
+// readTreeDir lists entries of the folder stored at path in the tree. The
+// modification time of all entries is the committer time of the commit.
+func readTreeDir(commit *object.Commit, tree *object.Tree, p string) ([]os.FileInfo, error) {
+	if tp := treePath(p); tp != "" {
+		var err error
+		tree, err = tree.Tree(tp)
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, &FolderNotFoundError{Path: p, message: fmt.Sprintf("%#q", p)}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var files []os.FileInfo
+	for _, entry := range tree.Entries {
+		mode, err := entry.Mode.ToOSFileMode()
+		if err != nil {
+			return nil, err
+		}
+
+		var size int64
+		if entry.Mode.IsFile() {
+			size, err = tree.Size(entry.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		files = append(files, &treeFileInfo{
+			name:    entry.Name,
+			size:    size,
+			mode:    mode,
+			modTime: commit.Committer.When,
+			isDir:   entry.Mode == filemode.Dir,
+		})
+	}
+
+	return files, nil
+}
+
+// treeFileInfo implements os.FileInfo for git tree entries.
+type treeFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	isDir   bool
+}
+
+func (i *treeFileInfo) Name() string       { return i.name }
+func (i *treeFileInfo) Size() int64        { return i.size }
+func (i *treeFileInfo) Mode() os.FileMode  { return i.mode }
+func (i *treeFileInfo) ModTime() time.Time { return i.modTime }
+func (i *treeFileInfo) IsDir() bool        { return i.isDir }
+func (i *treeFileInfo) Sys() interface{}   { return nil }
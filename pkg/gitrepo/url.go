@@ -0,0 +1,109 @@
+package gitrepo
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+)
+
+var defaultPorts = map[string]string{
+	"git":   "9418",
+	"http":  "80",
+	"https": "443",
+	"ssh":   "22",
+}
+
+// normalizeURL returns the form of a remote URL used to compare URLs of the
+// same repository written differently. Scheme, user and default port are
+// dropped, host and path are lower-cased and the ".git" suffix is trimmed, so
+// e.g. "git@github.com:Org/Repo.git" and "https://github.com/org/repo" are
+// both normalized to "github.com/org/repo". Local paths are only cleaned.
+func normalizeURL(rawURL string) string {
+	s := strings.TrimSpace(rawURL)
+
+	if !strings.Contains(s, "://") {
+		// scp-like syntax "user@host:path" is recognized the same way
+		// git does it, i.e. by a colon before the first slash. Single
+		// letter hosts are Windows drive letters.
+		host, path, ok := strings.Cut(s, ":")
+		if !ok || len(host) <= 1 || strings.Contains(host, "/") {
+			return filepath.Clean(s)
+		}
+		s = "ssh://" + host + "/" + path
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.Scheme == "file" {
+		return filepath.Clean(u.Path)
+	}
+
+	host := u.Hostname()
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+
+	path := strings.ToLower(strings.Trim(u.Path, "/"))
+	path = strings.TrimSuffix(path, ".git")
+
+	return strings.ToLower(host) + "/" + path
+}
+
+// checkRemoteURL compares the URL of a remote stored in the existing
+// repository config with the configured one. When they do not match the
+// remote config is updated if Config.UpdateRemoteURL is set and true is
+// returned. Otherwise RemoteURLMismatchError is returned.
+func (r *Repo) checkRemoteURL(remote *config.RemoteConfig, expectedURL string) (bool, error) {
+	if len(remote.URLs) > 0 && normalizeURL(remote.URLs[0]) == normalizeURL(expectedURL) {
+		return false, nil
+	}
+
+	if !r.updateRemoteURL {
+		urls := make([]string, len(remote.URLs))
+		for i, u := range remote.URLs {
+			urls[i] = redact(u, urlSecret(u))
+		}
+		redacted := redact(expectedURL, urlSecret(expectedURL))
+
+		return false, &RemoteURLMismatchError{URL: redacted, message: fmt.Sprintf("remote %#q URLs %#q do not match %#q", remote.Name, urls, redacted)}
+	}
+
+	remote.URLs = []string{expectedURL}
+
+	return true, nil
+}
+
+// urlSecret returns the secret embedded in the URL, i.e. the password or the
+// user of HTTP URLs which is commonly a token. It returns empty string when
+// there is none.
+func urlSecret(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return ""
+	}
+
+	if password, ok := u.User.Password(); ok {
+		return password
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return u.User.Username()
+	}
+
+	return ""
+}
+
+// redact replaces the secrets in s.
+func redact(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "xxxxx")
+		}
+	}
+
+	return s
+}
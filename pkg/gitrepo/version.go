@@ -0,0 +1,488 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// VersionFormat defines how versions returned by Repo.ResolveVersion are
+// formatted.
+type VersionFormat string
+
+const (
+	// VersionFormatDefault formats versions as "X.Y.Z" for tagged
+	// references and "X.Y.Z-SHA" for untagged ones, where "SHA" is the
+	// full 40 characters long git SHA.
+	VersionFormatDefault VersionFormat = ""
+	// VersionFormatGo formats versions as Go module versions. Tagged
+	// references resolve to "vX.Y.Z" and untagged ones to pseudo-versions
+	// as described in https://go.dev/ref/mod#pseudo-versions:
+	//
+	//	vX.0.0-yyyymmddhhmmss-abcdefabcdef when there is no tagged parent,
+	//	vX.Y.(Z+1)-0.yyyymmddhhmmss-abcdefabcdef when the tagged parent is vX.Y.Z,
+	//	vX.Y.Z-pre.0.yyyymmddhhmmss-abcdefabcdef when the tagged parent is vX.Y.Z-pre.
+	//
+	// The timestamp is the UTC committer time of the resolved commit.
+	VersionFormatGo VersionFormat = "go"
+)
+
+func (f VersionFormat) validate() error {
+	switch f {
+	case VersionFormatDefault, VersionFormatGo:
+		return nil
+	}
+
+	return &InvalidConfigError{message: fmt.Sprintf("unknown version format %#q", f)}
+}
+
+// VersionStrategy defines how the version of the tagged parent is selected
+// when multiple tagged parents are reachable from a commit.
+type VersionStrategy string
+
+const (
+	// VersionStrategyMostRecent selects the version of the first tagged
+	// parent found when walking the history by committer date in
+	// descending order.
+	VersionStrategyMostRecent VersionStrategy = ""
+	// VersionStrategyHighest collects versions of all tagged parents
+	// reachable without crossing another tagged commit, i.e. up to the
+	// merge frontier, and selects the highest one by semantic version
+	// precedence. Versions which are not valid semantic versions are
+	// considered lower than all valid ones.
+	VersionStrategyHighest VersionStrategy = "highest"
+)
+
+func (s VersionStrategy) validate() error {
+	switch s {
+	case VersionStrategyMostRecent, VersionStrategyHighest:
+		return nil
+	}
+
+	return &InvalidConfigError{message: fmt.Sprintf("unknown version strategy %#q", s)}
+}
+
+// formatVersion formats version of a tagged commit. The version is the tag
+// with all prefixes trimmed, e.g. "1.2.3".
+func formatVersion(format VersionFormat, version string) (string, error) {
+	if format != VersionFormatGo {
+		return version, nil
+	}
+
+	v := "v" + version
+	if !semver.IsValid(v) {
+		return "", &ExecutionFailedError{message: fmt.Sprintf("version %#q is not a valid semantic version", version)}
+	}
+
+	return v, nil
+}
+
+// formatPseudoVersion formats version of an untagged commit. The base is the
+// version of the most recent tagged parent with all prefixes trimmed, e.g.
+// "1.2.3", or empty when there is no such parent.
+func formatPseudoVersion(format VersionFormat, base string, commit *object.Commit) (string, error) {
+	if format != VersionFormatGo {
+		if base == "" {
+			base = "0.0.0"
+		}
+
+		return base + "-" + commit.Hash.String(), nil
+	}
+
+	var older string
+	if base != "" {
+		older = "v" + base
+		if !semver.IsValid(older) {
+			return "", &ExecutionFailedError{message: fmt.Sprintf("version %#q is not a valid semantic version", base)}
+		}
+	}
+
+	rev := commit.Hash.String()[:12]
+
+	return module.PseudoVersion("", older, commit.Committer.When, rev), nil
+}
+
+// versionsByHash returns versions of version tags of the module by SHA of the
+// tagged commit.
+func (r *Repo) versionsByHash(ctx context.Context, repo *git.Repository) (map[string]string, error) {
+	matcher := r.tagMatcher()
+
+	tagsByHash, err := r.tags(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	versionsByHash := map[string]string{}
+	for hash, tags := range tagsByHash {
+		for _, t := range tags {
+			var versionTags []string
+
+			if v, ok := matcher.version(t); ok {
+				versionTags = append(versionTags, t)
+				versionsByHash[hash] = v
+				candidates = append(candidates, t)
+			}
+
+			if len(versionTags) > 1 {
+				return nil, &ExecutionFailedError{message: fmt.Sprintf("multiple version tags %#v found for hash %#q", versionTags, hash)}
+			}
+		}
+	}
+
+	if r.logger.Enabled(ctx, slog.LevelDebug) {
+		sort.Strings(candidates)
+		r.logger.DebugContext(ctx, "found version tags", slog.Any("tags", candidates))
+	}
+
+	return versionsByHash, nil
+}
+
+// baseVersion returns version and hash of the tagged parent of commit,
+// including the commit itself, selected with Config.VersionStrategy. The
+// version is empty when there is no tagged parent.
+func (r *Repo) baseVersion(ctx context.Context, commit *object.Commit, versionsByHash map[string]string) (string, plumbing.Hash, error) {
+	ctx, span := r.startSpan(ctx, SpanVersionWalk, slog.String("strategy", string(r.versionStrategy)), slog.String("commit", commit.Hash.String()))
+
+	var version string
+	var hash plumbing.Hash
+	var err error
+	switch r.versionStrategy {
+	case VersionStrategyHighest:
+		version, hash, err = highestVersion(ctx, r.logger, commit, versionsByHash)
+	default:
+		version, hash, err = mostRecentVersion(ctx, commit, versionsByHash)
+	}
+	if err != nil {
+		span.End(err)
+		return "", plumbing.ZeroHash, err
+	}
+
+	span.SetAttributes(slog.String("version", version), slog.String("version_commit", hash.String()))
+	span.End(nil)
+
+	if version == "" {
+		r.logger.DebugContext(ctx, "found no tagged parent", slog.String("commit", commit.Hash.String()))
+	} else {
+		r.logger.DebugContext(ctx, "found base version",
+			slog.String("commit", commit.Hash.String()),
+			slog.String("version", version),
+			slog.String("version_commit", hash.String()),
+		)
+	}
+
+	return version, hash, nil
+}
+
+// mostRecentVersion returns version and hash of the first tagged parent found
+// when walking the history by committer date in descending order.
+func mostRecentVersion(ctx context.Context, commit *object.Commit, versionsByHash map[string]string) (string, plumbing.Hash, error) {
+	queue := []*object.Commit{
+		commit,
+	}
+
+	for {
+		if len(queue) == 0 {
+			break
+		}
+
+		err := checkContext(ctx)
+		if err != nil {
+			return "", plumbing.ZeroHash, err
+		}
+
+		// Pop the first element from the queue.
+		c := queue[0]
+		queue = queue[1:]
+
+		// Check if this commit is tagged. If so the most recent tag
+		// is found and loop should be finished.
+		v, ok := versionsByHash[c.Hash.String()]
+		if ok {
+			return v, c.Hash, nil
+		}
+
+		// Push all the parents to the queue.
+		err = c.Parents().ForEach(func(p *object.Commit) error {
+			// If the commit is already in the queue skip it. This
+			// is possible multiple commits have the same parent.
+			// Adding all of them to the queue may lead in
+			// exponential growth of the queue resulting in
+			// extremely long execution.
+			for _, c := range queue {
+				if c.Hash == p.Hash {
+					return nil
+				}
+			}
+
+			queue = append(queue, p)
+			return nil
+		})
+		if err != nil {
+			return "", plumbing.ZeroHash, err
+		}
+
+		// Sort commits in the queue by commit date in descending
+		// order to find the most recent tag first.
+		sort.Slice(queue, func(i, j int) bool { return queue[i].Committer.When.After(queue[j].Committer.When) })
+	}
+
+	return "", plumbing.ZeroHash, nil
+}
+
+// highestVersion returns version and hash of the tagged parent with the
+// highest version by semantic version precedence. The walk does not go past
+// tagged commits so only the tagged parents on the merge frontier are
+// compared. Each of them is logged.
+func highestVersion(ctx context.Context, logger *slog.Logger, commit *object.Commit, versionsByHash map[string]string) (string, plumbing.Hash, error) {
+	var found bool
+	var version string
+	var hash plumbing.Hash
+
+	seen := map[plumbing.Hash]bool{
+		commit.Hash: true,
+	}
+	queue := []*object.Commit{
+		commit,
+	}
+
+	for len(queue) > 0 {
+		err := checkContext(ctx)
+		if err != nil {
+			return "", plumbing.ZeroHash, err
+		}
+
+		c := queue[0]
+		queue = queue[1:]
+
+		v, ok := versionsByHash[c.Hash.String()]
+		if ok {
+			logger.DebugContext(ctx, "found candidate version", slog.String("version", v), slog.String("version_commit", c.Hash.String()))
+
+			if !found || semver.Compare("v"+v, "v"+version) > 0 {
+				found = true
+				version = v
+				hash = c.Hash
+			}
+
+			continue
+		}
+
+		err = c.Parents().ForEach(func(p *object.Commit) error {
+			if seen[p.Hash] {
+				return nil
+			}
+
+			seen[p.Hash] = true
+			queue = append(queue, p)
+			return nil
+		})
+		if err != nil {
+			return "", plumbing.ZeroHash, err
+		}
+	}
+
+	return version, hash, nil
+}
+
+// validateVersionPaths normalizes paths configured for path-scoped
+// versioning. Paths are relative to the repository root.
+func validateVersionPaths(paths []string) ([]string, error) {
+	var normalized []string
+	for _, p := range paths {
+		tp := treePath(p)
+		if tp == "" {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("version path %#q must point to a subdirectory or a file of the repository", p)}
+		}
+
+		normalized = append(normalized, tp)
+	}
+
+	return normalized, nil
+}
+
+// versionBase returns the base version of commit found by walking the tags
+// from commit, the hash of the tagged commit, and the commit the version is
+// resolved for. The latter is commit itself unless version paths are
+// configured.
+//
+// With version paths it is the most recent commit touching them which is not
+// released with the base version yet. When there is none, e.g. the base
+// version is tagged on a release commit changing only a root CHANGELOG or on
+// a merge commit, it is the tagged commit. It returns ReferenceNotFoundError
+// when there is neither the base version nor a commit touching the paths.
+func (r *Repo) versionBase(ctx context.Context, repo *git.Repository, commit *object.Commit, versionsByHash map[string]string) (*object.Commit, string, plumbing.Hash, error) {
+	version, hash, err := r.baseVersion(ctx, commit, versionsByHash)
+	if err != nil {
+		return nil, "", plumbing.ZeroHash, err
+	}
+
+	if len(r.versionPaths) == 0 {
+		return commit, version, hash, nil
+	}
+
+	var released map[plumbing.Hash]bool
+	if version != "" {
+		released, err = reachableCommits(ctx, repo, hash)
+		if err != nil {
+			return nil, "", plumbing.ZeroHash, err
+		}
+	}
+
+	found, err := latestPathCommit(ctx, commit, released, r.versionPaths)
+	if err != nil {
+		return nil, "", plumbing.ZeroHash, err
+	}
+
+	if found == nil && version == "" {
+		return nil, "", plumbing.ZeroHash, &ReferenceNotFoundError{Ref: commit.Hash.String(), message: fmt.Sprintf("no commit reachable from %#q touches paths %v", commit.Hash, r.versionPaths)}
+	}
+	if found == nil {
+		found, err = repo.CommitObject(hash)
+		if err != nil {
+			return nil, "", plumbing.ZeroHash, err
+		}
+	}
+
+	return found, version, hash, nil
+}
+
+// reachableCommits returns hashes of the commits reachable from the commit
+// with the hash, including the commit itself.
+func reachableCommits(ctx context.Context, repo *git.Repository, hash plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := map[plumbing.Hash]bool{}
+
+	iter := object.NewCommitPreorderIter(commit, nil, nil)
+	defer iter.Close()
+
+	err = iter.ForEach(func(c *object.Commit) error {
+		err := checkContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		reachable[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reachable, nil
+}
+
+// latestPathCommit returns the most recent commit, by committer time,
+// reachable from commit which touches any of the paths. Commits in skip and
+// their parents are not visited. It returns nil when no commit touches the
+// paths.
+//
+// Same as in git log, a commit touches the paths when their content differs
+// from each of its parents. That means merge commits taking the content
+// unchanged from one of the parents do not touch the paths. Root commits
+// touch the paths when any of them exists.
+func latestPathCommit(ctx context.Context, commit *object.Commit, skip map[plumbing.Hash]bool, paths []string) (*object.Commit, error) {
+	var found *object.Commit
+
+	iter := object.NewCommitIterCTime(commit, skip, nil)
+	defer iter.Close()
+
+	err := iter.ForEach(func(c *object.Commit) error {
+		err := checkContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		touches, err := touchesPaths(c, paths)
+		if err != nil {
+			return err
+		}
+
+		if touches {
+			found = c
+			return storer.ErrStop
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+func touchesPaths(c *object.Commit, paths []string) (bool, error) {
+	hashes, err := pathHashes(c, paths)
+	if err != nil {
+		return false, err
+	}
+
+	if c.NumParents() == 0 {
+		for _, h := range hashes {
+			if !h.IsZero() {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	touches := true
+	err = c.Parents().ForEach(func(p *object.Commit) error {
+		parentHashes, err := pathHashes(p, paths)
+		if err != nil {
+			return err
+		}
+
+		for i := range hashes {
+			if hashes[i] != parentHashes[i] {
+				return nil
+			}
+		}
+
+		touches = false
+		return storer.ErrStop
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return touches, nil
+}
+
+// pathHashes returns hashes of tree entries found at the paths in the commit
+// tree. The hash is zero for paths which do not exist.
+func pathHashes(c *object.Commit, paths []string) ([]plumbing.Hash, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]plumbing.Hash, len(paths))
+	for i, p := range paths {
+		entry, err := tree.FindEntry(p)
+		if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		hashes[i] = entry.Hash
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,94 @@
+package gitrepo
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func Test_formatPseudoVersion(t *testing.T) {
+	t.Parallel()
+
+	commit := &object.Commit{
+		Hash: plumbing.NewHash("2091354c7b8659f1846a876fbe2032fd1390d569"),
+		Committer: object.Signature{
+			When: time.Date(2019, 10, 10, 12, 30, 45, 0, time.FixedZone("CEST", 2*3600)),
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		inputFormat     VersionFormat
+		inputBase       string
+		expectedVersion string
+		expectedError   error
+	}{
+		{
+			name:            "case 0: default format without base",
+			inputFormat:     VersionFormatDefault,
+			expectedVersion: "0.0.0-2091354c7b8659f1846a876fbe2032fd1390d569",
+		},
+		{
+			name:            "case 1: default format with base",
+			inputFormat:     VersionFormatDefault,
+			inputBase:       "1.2.3",
+			expectedVersion: "1.2.3-2091354c7b8659f1846a876fbe2032fd1390d569",
+		},
+		{
+			name:            "case 2: go format without base",
+			inputFormat:     VersionFormatGo,
+			expectedVersion: "v0.0.0-20191010103045-2091354c7b86",
+		},
+		{
+			name:            "case 3: go format with release base",
+			inputFormat:     VersionFormatGo,
+			inputBase:       "1.2.3",
+			expectedVersion: "v1.2.4-0.20191010103045-2091354c7b86",
+		},
+		{
+			name:            "case 4: go format with release base and patch carry",
+			inputFormat:     VersionFormatGo,
+			inputBase:       "1.2.9",
+			expectedVersion: "v1.2.10-0.20191010103045-2091354c7b86",
+		},
+		{
+			name:            "case 5: go format with pre-release base",
+			inputFormat:     VersionFormatGo,
+			inputBase:       "2.0.0-rc.1",
+			expectedVersion: "v2.0.0-rc.1.0.20191010103045-2091354c7b86",
+		},
+		{
+			name:          "case 6: go format with invalid base",
+			inputFormat:   VersionFormatGo,
+			inputBase:     "1.2.3.4",
+			expectedError: &ExecutionFailedError{},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Log(tc.name)
+
+			version, err := formatPseudoVersion(tc.inputFormat, tc.inputBase, commit)
+
+			switch {
+			case err == nil && tc.expectedError == nil:
+				// correct; carry on
+			case err != nil && tc.expectedError == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.expectedError != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case reflect.TypeOf(tc.expectedError) != reflect.TypeOf(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+
+			if version != tc.expectedVersion {
+				t.Fatalf("version = %q, want %q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	defaultWatcherInterval = time.Minute
+	defaultWatcherJitter   = 0.1
+)
+
+// EventType is the type of Event published by Watcher.
+type EventType string
+
+const (
+	EventBranchCreated EventType = "BranchCreated"
+	// EventBranchMoved is published when a branch is fast-forwarded.
+	EventBranchMoved EventType = "BranchMoved"
+	// EventBranchForcePushed is published when a branch moves to a commit
+	// which does not descend from the previous one.
+	EventBranchForcePushed EventType = "BranchForcePushed"
+	// EventBranchDeleted is published only when Config.Prune is set for
+	// the Repo.
+	EventBranchDeleted EventType = "BranchDeleted"
+	EventTagCreated    EventType = "TagCreated"
+	EventTagMoved      EventType = "TagMoved"
+	// EventTagDeleted is published only when Config.Prune is set for the
+	// Repo.
+	EventTagDeleted EventType = "TagDeleted"
+	// EventError is published when fetching a Repo fails. The Repo is
+	// polled again after a backoff.
+	EventError EventType = "Error"
+)
+
+// Event is a change of a reference in a watched repository.
+type Event struct {
+	Type EventType
+	// Repo is the watched repository the event comes from.
+	Repo *Repo
+	// URL is the URL of the watched repository with the password
+	// redacted.
+	URL string
+	// Name is the short name of the branch, e.g. "master", or tag, e.g.
+	// "v1.2.3".
+	Name string
+	// Update is the reference update the event is made of. It is empty
+	// for EventError.
+	Update RefUpdate
+	// Err is the fetch error for EventError.
+	Err error
+}
+
+type WatcherConfig struct {
+	// Repos are the repositories to watch. Each of them is fetched with
+	// EnsureUpToDateWithReport independently.
+	Repos []*Repo
+	// Interval is the time between fetches of a repository. Defaults to
+	// one minute.
+	Interval time.Duration
+	// Jitter is the fraction of the interval by which the time between
+	// fetches is randomly shortened or extended, so multiple watchers do
+	// not hit the remote at the same time. Defaults to 0.1. Set it to
+	// a negative value to disable jitter.
+	Jitter float64
+	// MaxBackoff is the maximum time between fetches of a repository which
+	// keeps failing. After each failure the time is doubled starting from
+	// Interval. Defaults to ten times Interval.
+	MaxBackoff time.Duration
+	// Handler is called with each event. Calls are serialized. When nil,
+	// events are published on the channel returned by Watcher.Events.
+	Handler func(Event)
+}
+
+// Watcher polls remotes of repositories and publishes events for the
+// references which changed.
+//
+// The first fetch of a repository which is not cloned yet only establishes
+// the baseline and does not publish events.
+type Watcher struct {
+	repos      []*Repo
+	interval   time.Duration
+	jitter     float64
+	maxBackoff time.Duration
+	handler    func(Event)
+
+	events chan Event
+
+	handlerMu sync.Mutex
+	randMu    sync.Mutex
+	rand      *rand.Rand
+}
+
+func NewWatcher(config WatcherConfig) (*Watcher, error) {
+	if len(config.Repos) == 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Repos must not be empty", config)}
+	}
+	seen := map[*Repo]bool{}
+	for i, r := range config.Repos {
+		if r == nil {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Repos[%d] must not be nil", config, i)}
+		}
+		if seen[r] {
+			return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Repos[%d] is duplicated", config, i)}
+		}
+		seen[r] = true
+	}
+	if config.Interval < 0 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Interval must not be negative", config)}
+	}
+	if config.Jitter >= 1 {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.Jitter must be lower than 1", config)}
+	}
+
+	if config.Interval == 0 {
+		config.Interval = defaultWatcherInterval
+	}
+	if config.Jitter == 0 {
+		config.Jitter = defaultWatcherJitter
+	}
+	if config.Jitter < 0 {
+		config.Jitter = 0
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 10 * config.Interval
+	}
+	if config.MaxBackoff < config.Interval {
+		return nil, &InvalidConfigError{message: fmt.Sprintf("%T.MaxBackoff must not be lower than %T.Interval", config, config)}
+	}
+
+	w := &Watcher{
+		repos:      config.Repos,
+		interval:   config.Interval,
+		jitter:     config.Jitter,
+		maxBackoff: config.MaxBackoff,
+		handler:    config.Handler,
+
+		events: make(chan Event),
+
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	return w, nil
+}
+
+// Events returns the channel events are published on when
+// WatcherConfig.Handler is not set. It is closed when Run returns.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Run polls the repositories until the context is canceled. It returns nil
+// after all the in-flight fetches are aborted. Run must be called only once.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer close(w.events)
+
+	var wg sync.WaitGroup
+	for _, r := range w.repos {
+		wg.Add(1)
+		go func(r *Repo) {
+			defer wg.Done()
+			w.watch(ctx, r)
+		}(r)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func (w *Watcher) watch(ctx context.Context, r *Repo) {
+	var failures int
+	for {
+		report, err := r.EnsureUpToDateWithReport(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			failures++
+			w.publish(ctx, Event{Type: EventError, Repo: r, URL: r.redactedURL(), Err: err})
+		} else {
+			failures = 0
+			if !report.Cloned {
+				for _, e := range newEvents(r, report) {
+					w.publish(ctx, e)
+				}
+			}
+		}
+
+		timer := time.NewTimer(w.delay(failures))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the time to wait before the next fetch after the number of
+// consecutive failures.
+func (w *Watcher) delay(failures int) time.Duration {
+	w.randMu.Lock()
+	f := w.rand.Float64()
+	w.randMu.Unlock()
+
+	return backoff(w.interval, w.maxBackoff, w.jitter, failures, f)
+}
+
+func (w *Watcher) publish(ctx context.Context, e Event) {
+	if w.handler != nil {
+		w.handlerMu.Lock()
+		defer w.handlerMu.Unlock()
+
+		w.handler(e)
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case w.events <- e:
+	}
+}
+
+// newEvents converts the report to events.
+func newEvents(r *Repo, report *UpdateReport) []Event {
+	var events []Event
+	for _, u := range report.Updates {
+		name := plumbing.ReferenceName(u.Name)
+
+		var t EventType
+		switch {
+		case name.IsRemote() && u.Created:
+			t = EventBranchCreated
+		case name.IsRemote() && u.Deleted:
+			t = EventBranchDeleted
+		case name.IsRemote() && u.Forced:
+			t = EventBranchForcePushed
+		case name.IsRemote():
+			t = EventBranchMoved
+		case name.IsTag() && u.Created:
+			t = EventTagCreated
+		case name.IsTag() && u.Deleted:
+			t = EventTagDeleted
+		case name.IsTag():
+			t = EventTagMoved
+		default:
+			continue
+		}
+
+		short := name.Short()
+		if name.IsRemote() {
+			// Trim the remote name, e.g. "origin/" in
+			// "origin/master".
+			_, short, _ = strings.Cut(short, "/")
+		}
+
+		events = append(events, Event{
+			Type:   t,
+			Repo:   r,
+			URL:    r.redactedURL(),
+			Name:   short,
+			Update: u,
+		})
+	}
+
+	return events
+}